@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"restaurant-management/database"
+	"restaurant-management/mailer"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var userTokenCollection *mongo.Collection = database.OpenCollection(database.Client, "userToken")
+
+var mail mailer.Mailer = mailer.New()
+
+// How long mailed tokens stay valid.
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 24 * time.Hour
+)
+
+func RequestPasswordReset() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Email *string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// The response is the same whether or not the email exists so the
+		// endpoint cannot be used to discover staff accounts.
+		msg := "if the email belongs to an account, a reset link has been sent"
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": body.Email}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": msg})
+			return
+		}
+
+		if err = sendUserToken(ctx, user, models.TokenPurposePasswordReset); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while sending the reset email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": msg})
+	}
+}
+
+func ResetPassword() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Token    *string `json:"token" validate:"required"`
+			Password *string `json:"password" validate:"required,min=6"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		userToken, err := consumeUserToken(ctx, *body.Token, models.TokenPurposePasswordReset)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reset token is invalid or has expired"})
+			return
+		}
+
+		password, err := HashPassword(*body.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while hashing the password"})
+			return
+		}
+
+		// Bumping the token version revokes every access token issued so far
+		// and clearing the refresh token stops them being renewed, which signs
+		// the user out of every session.
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": userToken.User_id}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "token_version", Value: 1}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "password", Value: password},
+				{Key: "token", Value: nil},
+				{Key: "refresh_token", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "password reset failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "password has been reset"})
+	}
+}
+
+func RequestEmailVerification() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("user_id")}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+			return
+		}
+		if user.Email_verified {
+			c.JSON(http.StatusOK, gin.H{"message": "email is already verified"})
+			return
+		}
+
+		if err = sendUserToken(ctx, user, models.TokenPurposeEmailVerification); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while sending the verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "verification email has been sent"})
+	}
+}
+
+func VerifyEmail() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Token *string `json:"token" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		userToken, err := consumeUserToken(ctx, *body.Token, models.TokenPurposeEmailVerification)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "verification token is invalid or has expired"})
+			return
+		}
+
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": userToken.User_id}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "email_verified", Value: true},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email verification failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email has been verified"})
+	}
+}
+
+// sendUserToken replaces any outstanding token of the same purpose with a
+// new one and mails it to the user.
+func sendUserToken(ctx context.Context, user models.User, purpose string) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = userTokenCollection.DeleteMany(ctx, bson.M{"user_id": user.User_id, "purpose": purpose, "used_at": nil})
+	if err != nil {
+		return err
+	}
+
+	ttl := passwordResetTTL
+	if purpose == models.TokenPurposeEmailVerification {
+		ttl = emailVerificationTTL
+	}
+
+	var userToken models.UserToken
+	userToken.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	userToken.ID = primitive.NewObjectID()
+	userToken.User_token_id = userToken.ID.Hex()
+	userToken.Token_hash = hashToken(token)
+	userToken.User_id = user.User_id
+	userToken.Purpose = purpose
+	userToken.Expires_at = time.Now().Add(ttl)
+
+	if _, err = userTokenCollection.InsertOne(ctx, userToken); err != nil {
+		return err
+	}
+
+	appUrl := appURL()
+
+	msg := mailer.Message{To: *user.Email}
+	switch purpose {
+	case models.TokenPurposePasswordReset:
+		msg.Subject = "Reset your password"
+		msg.Body = fmt.Sprintf("Use the link below to choose a new password. It expires in %s.\n\n%s/reset-password?token=%s\n\nIf you did not ask for this, ignore this email.", ttl, appUrl, token)
+	default:
+		msg.Subject = "Verify your email address"
+		msg.Body = fmt.Sprintf("Use the link below to verify your email address. It expires in %s.\n\n%s/verify-email?token=%s", ttl, appUrl, token)
+	}
+
+	return mail.Send(msg)
+}
+
+// consumeUserToken marks an unused, unexpired token as used and returns it.
+func consumeUserToken(ctx context.Context, token string, purpose string) (models.UserToken, error) {
+	var userToken models.UserToken
+
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": hashToken(token),
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	err := userTokenCollection.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "used_at", Value: now}}},
+	}).Decode(&userToken)
+
+	return userToken, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// appURL is the address of the front end links sent to people point to.
+func appURL() string {
+	appUrl := os.Getenv("APP_URL")
+	if appUrl == "" {
+		appUrl = "http://localhost:8000"
+	}
+	return appUrl
+}
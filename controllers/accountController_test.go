@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/mailer"
+	"restaurant-management/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	pingOnce sync.Once
+	pingErr  error
+)
+
+// requireDatabase skips the test when MongoDB cannot be reached. The
+// database is only pinged once per test run.
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	pingOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		pingErr = database.Client.Ping(ctx, nil)
+	})
+	if pingErr != nil {
+		t.Skipf("MongoDB is not reachable: %v", pingErr)
+	}
+}
+
+// performJSON runs handler on a POST request with body encoded as JSON.
+func performJSON(handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/", handler)
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+var resetTokenPattern = regexp.MustCompile(`reset-password\?token=([0-9a-f]+)`)
+
+func TestPasswordReset(t *testing.T) {
+	requireDatabase(t)
+
+	memory := mailer.NewMemoryMailer()
+	previous := mail
+	mail = memory
+	defer func() { mail = previous }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	password, err := HashPassword("old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := primitive.NewObjectID().Hex() + "@reset.test"
+	role := models.RoleWaiter
+	user := models.User{ID: primitive.NewObjectID(), Email: &email, Password: &password, Role: &role}
+	user.User_id = user.ID.Hex()
+	if _, err = userCollection.InsertOne(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	defer userCollection.DeleteOne(context.Background(), bson.M{"user_id": user.User_id})
+	defer userTokenCollection.DeleteMany(context.Background(), bson.M{"user_id": user.User_id})
+
+	accessToken, _, err := helper.GenerateTokens(email, user.User_id, role, user.Token_version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := helper.ValidateToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = helper.ValidateTokenVersion(ctx, claims); err != nil {
+		t.Fatalf("token before the reset: %v", err)
+	}
+
+	unknown := primitive.NewObjectID().Hex() + "@reset.test"
+	if w := performJSON(RequestPasswordReset(), gin.H{"email": unknown}); w.Code != http.StatusOK {
+		t.Fatalf("request for an unknown email: status %d, %s", w.Code, w.Body)
+	}
+	if sent := memory.Messages(); len(sent) != 0 {
+		t.Fatalf("%d messages sent for an unknown email", len(sent))
+	}
+
+	if w := performJSON(RequestPasswordReset(), gin.H{"email": email}); w.Code != http.StatusOK {
+		t.Fatalf("request: status %d, %s", w.Code, w.Body)
+	}
+	sent := memory.Messages()
+	if len(sent) != 1 || sent[0].To != email {
+		t.Fatalf("expected one message to %s, got %+v", email, sent)
+	}
+	match := resetTokenPattern.FindStringSubmatch(sent[0].Body)
+	if match == nil {
+		t.Fatalf("no reset link in %q", sent[0].Body)
+	}
+	resetToken := match[1]
+
+	if w := performJSON(ResetPassword(), gin.H{"token": "not-a-token", "password": "new-password"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("reset with a wrong token: status %d, %s", w.Code, w.Body)
+	}
+	if w := performJSON(ResetPassword(), gin.H{"token": resetToken, "password": "new-password"}); w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d, %s", w.Code, w.Body)
+	}
+	if w := performJSON(ResetPassword(), gin.H{"token": resetToken, "password": "other-password"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("reset with a used token: status %d, %s", w.Code, w.Body)
+	}
+
+	var foundUser models.User
+	if err = userCollection.FindOne(ctx, bson.M{"user_id": user.User_id}).Decode(&foundUser); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := VerifyPassword("new-password", *foundUser.Password); !ok {
+		t.Fatal("password was not changed")
+	}
+	if foundUser.Refresh_Token != nil {
+		t.Fatal("refresh token was not cleared")
+	}
+	if err = helper.ValidateTokenVersion(ctx, claims); !errors.Is(err, helper.ErrTokenRevoked) {
+		t.Fatalf("token after the reset: got %v, want %v", err, helper.ErrTokenRevoked)
+	}
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/middleware"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var adjustmentCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItemAdjustment")
+
+// adjustmentReasons are the reason codes accepted for voids, comps and refunds.
+const adjustmentReasons = "oneof=ENTERED_IN_ERROR CUSTOMER_CHANGED_MIND CUSTOMER_COMPLAINT QUALITY_ISSUE LONG_WAIT OVERCHARGE DUPLICATE_PAYMENT MANAGER_COMP STAFF_MEAL OTHER"
+
+// errApprovalRequired is returned when staff other than managers void, comp
+// or refund without a manager's approval.
+var errApprovalRequired = errors.New("a manager must approve this")
+
+// errApproverNotManager is returned when the approval is not a manager's.
+var errApproverNotManager = errors.New("only managers may approve this")
+
+// managerApproval returns the id of the manager approving a void, comp or
+// refund: the requester when they are a manager themselves, otherwise the
+// manager whose PIN was sent along.
+func managerApproval(ctx context.Context, c *gin.Context, approval *models.Approval) (string, error) {
+	if middleware.HasRole(c, models.RoleOwner, models.RoleManager) {
+		return c.GetString("user_id"), nil
+	}
+	if approval == nil {
+		return "", errApprovalRequired
+	}
+
+	approver, err := verifyUserPin(ctx, approval.User_id, approval.Pin)
+	if err != nil {
+		return "", err
+	}
+	if approver.Role == nil || (*approver.Role != models.RoleOwner && *approver.Role != models.RoleManager) {
+		return "", errApproverNotManager
+	}
+	return approver.User_id, nil
+}
+
+func respondApprovalError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errApprovalRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "APPROVAL_REQUIRED"})
+	case errors.Is(err, errApproverNotManager):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "APPROVER_NOT_MANAGER"})
+	default:
+		respondPinError(c, err)
+	}
+}
+
+// GetAdjustments lists voids and comps, newest first. The order_id,
+// order_item_id, type and reason query parameters narrow the list down.
+func GetAdjustments() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"order_id", "order_item_id", "type", "reason"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		result, err := adjustmentCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing adjustments"})
+			return
+		}
+
+		allAdjustments := []bson.M{}
+		if err = result.All(ctx, &allAdjustments); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing adjustments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allAdjustments)
+	}
+}
+
+// AdjustOrderItem voids or comps some or, by default, all of the remaining
+// quantity of an order item. It needs a reason code and a manager's
+// approval, and is refused once payments were taken on the order: the
+// payment is refunded instead. Unpaid invoices of the order are recomputed;
+// split ones are dropped, to be split again.
+func AdjustOrderItem() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Type     string           `json:"type" validate:"required,eq=VOID|eq=COMP"`
+			Quantity int              `json:"quantity" validate:"min=0"`
+			Reason   string           `json:"reason" validate:"required"`
+			Note     *string          `json:"note" validate:"omitempty,max=500"`
+			Approval *models.Approval `json:"approval"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if validationErr := validate.Var(body.Reason, adjustmentReasons); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown reason code " + body.Reason, "code": "UNKNOWN_REASON"})
+			return
+		}
+
+		var orderItem models.OrderItem
+		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": c.Param("orderItem_id")}).Decode(&orderItem)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+			return
+		}
+		if orderItem.Parent_order_item_id != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "bundle components are changed through their bundle", "code": "BUNDLE_COMPONENT"})
+			return
+		}
+		if orderItem.Awaiting_confirmation {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item awaits confirmation, reject it instead", "code": "AWAITING_CONFIRMATION"})
+			return
+		}
+
+		quantity := 0
+		if orderItem.Quantity != nil {
+			quantity = *orderItem.Quantity
+		}
+		remaining := quantity - orderItem.Voided_quantity - orderItem.Comped_quantity
+		if remaining <= 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item was already voided or comped", "code": "ITEM_ALREADY_ADJUSTED"})
+			return
+		}
+		if body.Quantity == 0 {
+			body.Quantity = remaining
+		}
+		if body.Quantity > remaining {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the quantity is more than what is left of the item", "code": "QUANTITY_EXCEEDED"})
+			return
+		}
+
+		approvedBy, err := managerApproval(ctx, c, body.Approval)
+		if err != nil {
+			respondApprovalError(c, err)
+			return
+		}
+
+		count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderItem.Order_id, "payment_status": bson.M{"$ne": models.InvoiceStatusPending}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while adjusting the order item"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "payments were taken on the order, refund them instead", "code": "ORDER_ALREADY_PAID"})
+			return
+		}
+
+		now := time.Now()
+		Created_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		adjustment := models.OrderItemAdjustment{
+			Order_id:      orderItem.Order_id,
+			Order_item_id: orderItem.Order_item_id,
+			Type:          body.Type,
+			Quantity:      body.Quantity,
+			Reason:        body.Reason,
+			Note:          body.Note,
+			Requested_by:  c.GetString("user_id"),
+			Approved_by:   approvedBy,
+			Created_at:    Created_at,
+		}
+		if orderItem.Unit_price != nil {
+			adjustment.Amount = helper.ToMinor(*orderItem.Unit_price) * int64(body.Quantity)
+		}
+		adjustment.ID = primitive.NewObjectID()
+		adjustment.Adjustment_id = adjustment.ID.Hex()
+
+		counter := "comped_quantity"
+		if body.Type == models.AdjustmentVoid {
+			counter = "voided_quantity"
+		}
+		set := bson.D{{Key: "updated_at", Value: Created_at}}
+		voided := body.Type == models.AdjustmentVoid && orderItem.Voided_quantity+body.Quantity == quantity
+		if voided {
+			set = append(set, bson.E{Key: "voided", Value: true})
+		}
+
+		var updatedItem models.OrderItem
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// Conditional on the counters read, so the same quantity cannot be
+			// adjusted twice.
+			filter := bson.M{
+				"order_item_id":   orderItem.Order_item_id,
+				"quantity":        orderItem.Quantity,
+				"voided_quantity": storedAmount(int64(orderItem.Voided_quantity)),
+				"comped_quantity": storedAmount(int64(orderItem.Comped_quantity)),
+			}
+			opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+			err := orderItemCollection.FindOneAndUpdate(sessCtx, filter, bson.D{
+				{Key: "$inc", Value: bson.D{{Key: counter, Value: body.Quantity}}},
+				{Key: "$set", Value: set},
+			}, opts).Decode(&updatedItem)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return &orderItemError{Status: http.StatusConflict, Code: "ITEM_CHANGED", Message: "the order item changed meanwhile, try again"}
+			}
+			if err != nil {
+				return err
+			}
+			_, err = adjustmentCollection.InsertOne(sessCtx, adjustment)
+			return err
+		})
+		if err != nil {
+			respondOrderItemError(c, err)
+			return
+		}
+
+		if body.Type == models.AdjustmentVoid {
+			voidInKitchen(ctx, updatedItem, body.Quantity)
+		}
+		refreshOrderInvoices(ctx, orderItem.Order_id)
+
+		c.JSON(http.StatusOK, gin.H{"adjustment": adjustment, "order_item": updatedItem})
+	}
+}
+
+// voidInKitchen tells the kitchen about a void and gives back the portions of
+// what it had not started on yet. The components of a voided bundle are
+// voided along.
+func voidInKitchen(ctx context.Context, orderItem models.OrderItem, quantity int) {
+	event := models.KitchenEventItemUpdated
+	if orderItem.Voided {
+		event = models.KitchenEventItemCancelled
+	}
+
+	if orderItem.Bundle_id == nil {
+		if orderItem.Food_id != nil && (orderItem.Item_status == nil || *orderItem.Item_status == models.ItemStatusQueued) {
+			releasePortions(ctx, []portionReservation{{Food_id: *orderItem.Food_id, Quantity: quantity}})
+		}
+		publishIfFired(ctx, event, orderItem.Order_id, []models.OrderItem{orderItem})
+		return
+	}
+
+	filter := bson.M{"parent_order_item_id": orderItem.Order_item_id}
+	_, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "voided_quantity", Value: orderItem.Voided_quantity},
+			{Key: "voided", Value: orderItem.Voided},
+			{Key: "updated_at", Value: orderItem.Updated_at},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	result, err := orderItemCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var components []models.OrderItem
+	if err = result.All(ctx, &components); err != nil {
+		log.Println(err)
+		return
+	}
+	var released []portionReservation
+	for _, component := range components {
+		if component.Food_id != nil && (component.Item_status == nil || *component.Item_status == models.ItemStatusQueued) {
+			released = append(released, portionReservation{Food_id: *component.Food_id, Quantity: quantity})
+		}
+	}
+	releasePortions(ctx, released)
+	publishIfFired(ctx, event, orderItem.Order_id, components)
+}
+
+// refreshOrderInvoices recomputes the unpaid invoices of an order after its
+// items changed, and drops unpaid split invoices, which no longer add up.
+func refreshOrderInvoices(ctx context.Context, orderId string) {
+	if err := recomputeOrderInvoices(ctx, orderId); err != nil {
+		log.Println(err)
+	}
+}
+
+// recomputeOrderInvoices is refreshOrderInvoices for callers that must act
+// on a failure, such as a transaction that moved the items.
+func recomputeOrderInvoices(ctx context.Context, orderId string) error {
+	_, err := invoiceCollection.DeleteMany(ctx, bson.M{"order_id": orderId, "payment_status": models.InvoiceStatusPending, "split_id": bson.M{"$ne": nil}})
+	if err != nil {
+		return err
+	}
+
+	result, err := invoiceCollection.Find(ctx, bson.M{"order_id": orderId, "payment_status": models.InvoiceStatusPending})
+	if err != nil {
+		return err
+	}
+	var invoices []models.Invoice
+	if err = result.All(ctx, &invoices); err != nil {
+		return err
+	}
+	for _, invoice := range invoices {
+		bill, settings, err := billOrder(ctx, orderId, invoice.Discount, invoice.Tip)
+		if err != nil {
+			return err
+		}
+		applyBill(&invoice, bill, settings)
+		filter := bson.M{"invoice_id": invoice.Invoice_id, "payment_status": models.InvoiceStatusPending}
+		if _, err = invoiceCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: billFields(invoice)}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var billingSettingsCollection *mongo.Collection = database.OpenCollection(database.Client, "billingSettings")
+
+// defaultBillingSettings apply until a manager saves settings of their own.
+var defaultBillingSettings = models.BillingSettings{
+	ID:       models.BillingSettingsId,
+	Currency: "USD",
+	Tax_mode: models.TaxModeExclusive,
+}
+
+func GetBillingSettings() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		settings, err := billingSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the billing settings"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// UpdateBillingSettings replaces the billing settings. Invoices already
+// created keep the settings they were computed with.
+func UpdateBillingSettings() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var settings models.BillingSettings
+		if err := c.BindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(settings); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		settings.ID = models.BillingSettingsId
+		settings.Updated_by = c.GetString("user_id")
+		settings.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		opts := options.Replace().SetUpsert(true)
+		_, err := billingSettingsCollection.ReplaceOne(ctx, bson.M{"_id": models.BillingSettingsId}, settings, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Billing settings update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+func billingSettings(ctx context.Context) (models.BillingSettings, error) {
+	var settings models.BillingSettings
+	err := billingSettingsCollection.FindOne(ctx, bson.M{"_id": models.BillingSettingsId}).Decode(&settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return defaultBillingSettings, nil
+	}
+	return settings, err
+}
+
+// billOrder computes the bill of an order from its billable items, less what
+// was voided or comped, the tax rates of their menu categories and the
+// billing settings. discount and tip are in minor units.
+func billOrder(ctx context.Context, orderId string, discount int64, tip int64) (helper.Bill, models.BillingSettings, error) {
+	input, _, settings, err := orderBillInput(ctx, orderId, discount, tip)
+	if err != nil {
+		return helper.Bill{}, settings, err
+	}
+	return helper.ComputeBill(input), settings, nil
+}
+
+// orderBillInput gathers what the bill of an order is computed from. The
+// lines of the input are in the order of the billable items returned; items
+// voided or comped entirely are left out.
+func orderBillInput(ctx context.Context, orderId string, discount int64, tip int64) (helper.BillInput, []models.OrderItem, models.BillingSettings, error) {
+	var input helper.BillInput
+	settings, err := billingSettings(ctx)
+	if err != nil {
+		return input, nil, settings, err
+	}
+
+	var items []models.OrderItem
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	result, err := orderItemCollection.Find(ctx, bson.M{
+		"order_id":              orderId,
+		"parent_order_item_id":  nil,
+		"awaiting_confirmation": bson.M{"$ne": true},
+		"voided":                bson.M{"$ne": true},
+	}, opts)
+	if err != nil {
+		return input, nil, settings, err
+	}
+	if err = result.All(ctx, &items); err != nil {
+		return input, nil, settings, err
+	}
+
+	categories, err := itemCategories(ctx, items)
+	if err != nil {
+		return input, nil, settings, err
+	}
+	rates, err := categoryTaxRates(ctx)
+	if err != nil {
+		return input, nil, settings, err
+	}
+
+	input = helper.BillInput{
+		Tax_inclusive:      settings.Tax_mode == models.TaxModeInclusive,
+		Service_charge_ppm: helper.RateToPpm(settings.Service_charge_percent),
+		Discount:           discount,
+		Tip:                tip,
+		Cash_rounding:      settings.Cash_rounding,
+	}
+	billable := []models.OrderItem{}
+	for _, item := range items {
+		charged := chargedQuantity(item)
+		if charged <= 0 {
+			continue
+		}
+		billable = append(billable, item)
+
+		var line helper.BillLine
+		if item.Unit_price != nil {
+			line.Amount = helper.ToMinor(*item.Unit_price) * int64(charged)
+		}
+		category := categories[item.Order_item_id]
+		line.Tax_rates = rates[category]
+		if _, ok := rates[category]; !ok {
+			line.Tax_rates = rates[""]
+		}
+		input.Lines = append(input.Lines, line)
+	}
+
+	return input, billable, settings, nil
+}
+
+// chargedQuantity is the quantity of an order item that is charged for, what
+// was ordered less what was voided or comped.
+func chargedQuantity(item models.OrderItem) int {
+	if item.Quantity == nil {
+		return 0
+	}
+	return *item.Quantity - item.Voided_quantity - item.Comped_quantity
+}
+
+// itemCategories returns the menu category of every order item, keyed by
+// order item id. Bundles take the category of the menu they are sold on.
+func itemCategories(ctx context.Context, items []models.OrderItem) (map[string]string, error) {
+	foodIds, bundleIds := []string{}, []string{}
+	for _, item := range items {
+		switch {
+		case item.Bundle_id != nil:
+			bundleIds = append(bundleIds, *item.Bundle_id)
+		case item.Food_id != nil:
+			foodIds = append(foodIds, *item.Food_id)
+		}
+	}
+
+	menuOf := map[string]string{}
+	var foods []models.Food
+	result, err := foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIds}})
+	if err != nil {
+		return nil, err
+	}
+	if err = result.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	for _, food := range foods {
+		if food.Menu_id != nil {
+			menuOf["food:"+food.Food_id] = *food.Menu_id
+		}
+	}
+	var bundles []models.Bundle
+	result, err = bundleCollection.Find(ctx, bson.M{"bundle_id": bson.M{"$in": bundleIds}})
+	if err != nil {
+		return nil, err
+	}
+	if err = result.All(ctx, &bundles); err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		if bundle.Menu_id != nil {
+			menuOf["bundle:"+bundle.Bundle_id] = *bundle.Menu_id
+		}
+	}
+
+	menuIds := []string{}
+	for _, menuId := range menuOf {
+		menuIds = append(menuIds, menuId)
+	}
+	var menus []models.Menu
+	result, err = menuCollection.Find(ctx, bson.M{"menu_id": bson.M{"$in": menuIds}})
+	if err != nil {
+		return nil, err
+	}
+	if err = result.All(ctx, &menus); err != nil {
+		return nil, err
+	}
+	categoryOf := map[string]string{}
+	for _, menu := range menus {
+		categoryOf[menu.Menu_id] = menu.Category
+	}
+
+	categories := map[string]string{}
+	for _, item := range items {
+		switch {
+		case item.Bundle_id != nil:
+			categories[item.Order_item_id] = categoryOf[menuOf["bundle:"+*item.Bundle_id]]
+		case item.Food_id != nil:
+			categories[item.Order_item_id] = categoryOf[menuOf["food:"+*item.Food_id]]
+		}
+	}
+	return categories, nil
+}
+
+// categoryTaxRates groups the tax rates by category. The default rates, the
+// ones without a category, are under the empty category.
+func categoryTaxRates(ctx context.Context) (map[string][]helper.BillTaxRate, error) {
+	var taxRates []models.TaxRate
+	result, err := taxRateCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err = result.All(ctx, &taxRates); err != nil {
+		return nil, err
+	}
+
+	rates := map[string][]helper.BillTaxRate{}
+	for _, taxRate := range taxRates {
+		category := ""
+		if taxRate.Category != nil {
+			category = *taxRate.Category
+		}
+		rate := helper.BillTaxRate{Tax_rate_id: taxRate.Tax_rate_id}
+		if taxRate.Name != nil {
+			rate.Name = *taxRate.Name
+		}
+		if taxRate.Rate != nil {
+			rate.Rate_ppm = helper.RateToPpm(*taxRate.Rate)
+		}
+		rates[category] = append(rates[category], rate)
+	}
+	return rates, nil
+}
+
+// applyBill stores a computed bill on an invoice.
+func applyBill(invoice *models.Invoice, bill helper.Bill, settings models.BillingSettings) {
+	invoice.Currency = settings.Currency
+	invoice.Tax_mode = settings.Tax_mode
+	invoice.Subtotal = bill.Subtotal
+	invoice.Discount = bill.Discount
+	invoice.Service_charge = bill.Service_charge
+	invoice.Taxes = []models.InvoiceTax{}
+	for _, tax := range bill.Taxes {
+		invoice.Taxes = append(invoice.Taxes, models.InvoiceTax(tax))
+	}
+	invoice.Tax_total = bill.Tax_total
+	invoice.Tip = bill.Tip
+	invoice.Rounding = bill.Rounding
+	invoice.Total = bill.Total
+}
+
+// billFields are the fields of an invoice set from its bill.
+func billFields(invoice models.Invoice) primitive.D {
+	return primitive.D{
+		{Key: "currency", Value: invoice.Currency},
+		{Key: "tax_mode", Value: invoice.Tax_mode},
+		{Key: "subtotal", Value: invoice.Subtotal},
+		{Key: "discount", Value: invoice.Discount},
+		{Key: "service_charge", Value: invoice.Service_charge},
+		{Key: "taxes", Value: invoice.Taxes},
+		{Key: "tax_total", Value: invoice.Tax_total},
+		{Key: "tip", Value: invoice.Tip},
+		{Key: "rounding", Value: invoice.Rounding},
+		{Key: "total", Value: invoice.Total},
+	}
+}
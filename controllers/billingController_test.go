@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"restaurant-management/models"
+	"testing"
+)
+
+func TestChargedQuantity(t *testing.T) {
+	quantity := func(n int) *int { return &n }
+
+	tests := []struct {
+		name string
+		item models.OrderItem
+		want int
+	}{
+		{name: "nothing adjusted", item: models.OrderItem{Quantity: quantity(3)}, want: 3},
+		{name: "part voided and comped", item: models.OrderItem{Quantity: quantity(5), Voided_quantity: 1, Comped_quantity: 2}, want: 2},
+		{name: "all comped", item: models.OrderItem{Quantity: quantity(2), Comped_quantity: 2}, want: 0},
+		{name: "adjusted beyond the quantity", item: models.OrderItem{Quantity: quantity(1), Comped_quantity: 3}, want: -2},
+		{name: "no quantity", item: models.OrderItem{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chargedQuantity(tt.item); got != tt.want {
+				t.Errorf("chargedQuantity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var bundleCollection *mongo.Collection = database.OpenCollection(database.Client, "bundle")
+
+func GetBundles() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := bundleCollection.Find(ctx, bson.M{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing bundles"})
+			return
+		}
+
+		allBundles := []bson.M{}
+		if err = result.All(ctx, &allBundles); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing bundles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allBundles)
+	}
+}
+
+func GetBundle() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		bundleId := c.Param("bundle_id")
+
+		var bundle models.Bundle
+		err := bundleCollection.FindOne(ctx, bson.M{"bundle_id": bundleId}).Decode(&bundle)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "bundle was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, bundle)
+	}
+}
+
+func CreateBundle() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var bundle models.Bundle
+		if err := c.BindJSON(&bundle); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(bundle)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if count, err := menuCollection.CountDocuments(ctx, bson.M{"menu_id": bundle.Menu_id}); err != nil || count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Menu was not Found"})
+			return
+		}
+		if err := checkBundleSlots(ctx, bundle.Slots); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		bundle.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		bundle.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		bundle.ID = primitive.NewObjectID()
+		bundle.Bundle_id = bundle.ID.Hex()
+		var num = toFixed(*bundle.Price, 2)
+		bundle.Price = &num
+
+		result, insertErr := bundleCollection.InsertOne(ctx, bundle)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Bundle was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func UpdateBundle() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var bundle models.Bundle
+		if err := c.BindJSON(&bundle); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		bundleId := c.Param("bundle_id")
+
+		var updateObj primitive.D
+
+		if bundle.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: bundle.Name})
+		}
+		if bundle.Price != nil {
+			var num = toFixed(*bundle.Price, 2)
+			updateObj = append(updateObj, bson.E{Key: "price", Value: num})
+		}
+		if bundle.Bundle_image != nil {
+			updateObj = append(updateObj, bson.E{Key: "bundle_image", Value: bundle.Bundle_image})
+		}
+		if bundle.Menu_id != nil {
+			if count, err := menuCollection.CountDocuments(ctx, bson.M{"menu_id": bundle.Menu_id}); err != nil || count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Menu was not Found"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "menu_id", Value: bundle.Menu_id})
+		}
+		if bundle.Slots != nil {
+			if validationErr := validate.Var(bundle.Slots, "min=1,dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			if err := checkBundleSlots(ctx, bundle.Slots); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "slots", Value: bundle.Slots})
+		}
+
+		bundle.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: bundle.Updated_at})
+
+		result, err := bundleCollection.UpdateOne(ctx, bson.M{"bundle_id": bundleId}, bson.D{
+			{Key: "$set", Value: updateObj},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Bundle update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "bundle was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// checkBundleSlots gives new slots an id and makes sure every food they
+// reference exists.
+func checkBundleSlots(ctx context.Context, slots []models.BundleSlot) error {
+	for i := range slots {
+		if slots[i].Slot_id == "" {
+			slots[i].Slot_id = primitive.NewObjectID().Hex()
+		}
+
+		foodIds := append([]string{*slots[i].Default_food_id}, slots[i].Allowed_food_ids...)
+		for _, foodId := range foodIds {
+			count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": foodId})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("food %s of slot %s was not found", foodId, *slots[i].Name)
+			}
+		}
+	}
+	return nil
+}
+
+// expandBundle prices a bundle line and returns the component items the
+// kitchen prepares for it, one per slot. The bundle price replaces the price
+// of the components; only the modifiers chosen on them are added.
+func expandBundle(ctx context.Context, orderItem *models.OrderItem) ([]models.OrderItem, error) {
+	var bundle models.Bundle
+	err := bundleCollection.FindOne(ctx, bson.M{"bundle_id": orderItem.Bundle_id}).Decode(&bundle)
+	if err == mongo.ErrNoDocuments {
+		return nil, &orderItemError{Status: http.StatusNotFound, Code: "BUNDLE_NOT_FOUND", Message: "Bundle was not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMenuAvailable(ctx, bundle.Menu_id); err != nil {
+		return nil, err
+	}
+
+	choices := map[string]models.BundleChoice{}
+	for _, choice := range orderItem.Bundle_choices {
+		choices[choice.Slot_id] = choice
+	}
+
+	price := *bundle.Price
+	var components []models.OrderItem
+
+	for _, slot := range bundle.Slots {
+		choice, chosen := choices[slot.Slot_id]
+		delete(choices, slot.Slot_id)
+
+		foodId := *slot.Default_food_id
+		if chosen && choice.Food_id != foodId {
+			allowed := false
+			for _, allowedId := range slot.Allowed_food_ids {
+				if allowedId == choice.Food_id {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return nil, &orderItemError{Status: http.StatusBadRequest, Code: "SUBSTITUTION_NOT_ALLOWED", Message: fmt.Sprintf("%s cannot be swapped for food %s", *slot.Name, choice.Food_id)}
+			}
+			foodId = choice.Food_id
+		}
+
+		var food models.Food
+		err = foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
+		if err == mongo.ErrNoDocuments {
+			return nil, &orderItemError{Status: http.StatusNotFound, Code: "FOOD_NOT_FOUND", Message: "Food was not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		component := models.OrderItem{
+			Food_id:  &foodId,
+			Quantity: orderItem.Quantity,
+		}
+		if chosen {
+			component.Modifiers = choice.Modifiers
+		}
+
+		modifiersPrice, err := resolveModifiers(food, component.Modifiers)
+		if err != nil {
+			return nil, err
+		}
+		price += modifiersPrice
+
+		var zero float64
+		component.Unit_price = &zero
+		components = append(components, component)
+	}
+
+	for slotId := range choices {
+		return nil, &orderItemError{Status: http.StatusBadRequest, Code: "BUNDLE_SLOT_NOT_FOUND", Message: fmt.Sprintf("%s has no slot %s", *bundle.Name, slotId)}
+	}
+
+	var num = toFixed(price, 2)
+	orderItem.Unit_price = &num
+	orderItem.Food_id = nil
+	orderItem.Variant_id = nil
+	orderItem.Variant_name = nil
+	orderItem.Modifiers = nil
+
+	return components, nil
+}
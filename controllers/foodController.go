@@ -16,17 +16,17 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/x/mongo/driver/mongocrypt/options"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var foodCollection *mongo.Collection = database.OpenCollection(database.Client, "food")
-var menuCollection *mongo.Collection = database.OpenCollection(database.Client, "menu")
 var validate = validator.New()
 
 func GetFoods() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
 
@@ -61,7 +61,6 @@ func GetFoods() gin.HandlerFunc {
 			projectStage,
 		})
 
-		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured"})
 		}
@@ -82,14 +81,13 @@ func GetFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		foodId := c.Param("food_id")
 		var food models.Food
 
 		err := foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
 
-		defer cancel()
-
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the food item"})
 		}
@@ -98,10 +96,50 @@ func GetFood() gin.HandlerFunc {
 	}
 }
 
+// GetAvailableFoods lists the foods of the menus being served right now,
+// leaving out those that are sold out.
+func GetAvailableFoods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		menus, err := availableMenus(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the available foods"})
+			return
+		}
+		menuIds := bson.A{}
+		for _, menu := range menus {
+			menuIds = append(menuIds, menu.Menu_id)
+		}
+
+		filter := bson.M{
+			"menu_id":            bson.M{"$in": menuIds},
+			"sold_out":           bson.M{"$ne": true},
+			"portions_remaining": bson.M{"$ne": 0},
+		}
+		result, err := foodCollection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the available foods"})
+			return
+		}
+		allFoods := []bson.M{}
+		if err = result.All(ctx, &allFoods); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the available foods"})
+			return
+		}
+		c.JSON(http.StatusOK, allFoods)
+	}
+}
+
 func CreateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var menu models.Menu
 		var food models.Food
@@ -117,7 +155,6 @@ func CreateFood() gin.HandlerFunc {
 		}
 
 		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
-		defer cancel()
 
 		if err != nil {
 			msg := fmt.Sprintf("Menu was not Found")
@@ -130,6 +167,8 @@ func CreateFood() gin.HandlerFunc {
 		food.Food_id = food.ID.Hex()
 		var num = toFixed(*food.Price, 2)
 		food.Price = &num
+		prepareVariants(food.Variants)
+		prepareOptionGroups(food.Option_groups)
 
 		result, insertErr := foodCollection.InsertOne(ctx, food)
 
@@ -138,10 +177,179 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
-		defer cancel()
 		c.JSON(http.StatusOK, result)
+
+	}
+}
+
+// MarkFoodSoldOut 86es a food: no more order items are taken for it until
+// the kitchen clears it again.
+func MarkFoodSoldOut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foodId := c.Param("food_id")
+		updateObj := primitive.D{{Key: "sold_out", Value: true}}
+		setFoodAvailability(ctx, c, foodId, updateObj)
+	}
+}
+
+// ClearFoodSoldOut takes a food off the 86 list. The optional portions query
+// parameter starts a new portion counter; without it the food is unlimited.
+func ClearFoodSoldOut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
+		foodId := c.Param("food_id")
+		var portions *int
+		if value := c.Query("portions"); value != "" {
+			num, err := strconv.Atoi(value)
+			if err != nil || num < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "portions must be a positive number"})
+				return
+			}
+			portions = &num
+		}
+
+		updateObj := primitive.D{
+			{Key: "sold_out", Value: false},
+			{Key: "portions_remaining", Value: portions},
+		}
+		setFoodAvailability(ctx, c, foodId, updateObj)
+	}
+}
+
+func setFoodAvailability(ctx context.Context, c *gin.Context, foodId string, updateObj primitive.D) {
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: Updated_at})
+
+	var food models.Food
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := foodCollection.FindOneAndUpdate(ctx, bson.M{"food_id": foodId}, bson.D{
+		{Key: "$set", Value: updateObj},
+	}, opts).Decode(&food)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "food was not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Food update failed"})
+		return
+	}
+	c.JSON(http.StatusOK, food)
+}
+
+// foodSoldOut reports whether a food is on the 86 list or out of portions.
+func foodSoldOut(food models.Food) bool {
+	return food.Sold_out || (food.Portions_remaining != nil && *food.Portions_remaining <= 0)
+}
+
+// portionReservation is a number of portions taken from a food's counter.
+type portionReservation struct {
+	Food_id  string
+	Quantity int
+}
+
+// reservePortions atomically takes portions for new order items from the
+// foods that count them. Either every reservation is made or none is: on
+// failure the ones already taken are given back. The reservations actually
+// taken from a counter are returned so the caller can release them.
+func reservePortions(ctx context.Context, requests []portionReservation) ([]portionReservation, error) {
+	var reserved []portionReservation
+
+	for _, request := range requests {
+		if request.Quantity <= 0 {
+			continue
+		}
+		filter := bson.M{
+			"food_id":            request.Food_id,
+			"sold_out":           bson.M{"$ne": true},
+			"portions_remaining": bson.M{"$gte": request.Quantity},
+		}
+		result, err := foodCollection.UpdateOne(ctx, filter, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "portions_remaining", Value: -request.Quantity}}},
+		})
+		if err != nil {
+			releasePortions(ctx, reserved)
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			reserved = append(reserved, request)
+			continue
+		}
+
+		// Not counted, or not enough left: find out which.
+		var food models.Food
+		if err = foodCollection.FindOne(ctx, bson.M{"food_id": request.Food_id}).Decode(&food); err != nil {
+			releasePortions(ctx, reserved)
+			if err == mongo.ErrNoDocuments {
+				return nil, &orderItemError{Status: http.StatusNotFound, Code: "FOOD_NOT_FOUND", Message: "Food was not found"}
+			}
+			return nil, err
+		}
+		if food.Sold_out || food.Portions_remaining != nil {
+			releasePortions(ctx, reserved)
+			return nil, soldOutError(food)
+		}
+	}
+	return reserved, nil
+}
+
+// releasePortions gives reserved portions back to their foods.
+func releasePortions(ctx context.Context, reserved []portionReservation) {
+	for _, reservation := range reserved {
+		if reservation.Quantity <= 0 {
+			continue
+		}
+		filter := bson.M{"food_id": reservation.Food_id, "portions_remaining": bson.M{"$ne": nil}}
+		_, err := foodCollection.UpdateOne(ctx, filter, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "portions_remaining", Value: reservation.Quantity}}},
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func soldOutError(food models.Food) error {
+	return &orderItemError{Status: http.StatusConflict, Code: "FOOD_SOLD_OUT", Message: fmt.Sprintf("%s is sold out", *food.Name)}
+}
+
+// prepareVariants gives new variants an id and rounds their price deltas.
+func prepareVariants(variants []models.FoodVariant) {
+	for i := range variants {
+		if variants[i].Variant_id == "" {
+			variants[i].Variant_id = primitive.NewObjectID().Hex()
+		}
+		if variants[i].Price_delta != nil {
+			var num = toFixed(*variants[i].Price_delta, 2)
+			variants[i].Price_delta = &num
+		}
+	}
+}
+
+// prepareOptionGroups gives new option groups and options an id and rounds
+// their price deltas.
+func prepareOptionGroups(groups []models.OptionGroup) {
+	for i := range groups {
+		if groups[i].Group_id == "" {
+			groups[i].Group_id = primitive.NewObjectID().Hex()
+		}
+		for j := range groups[i].Options {
+			option := &groups[i].Options[j]
+			if option.Option_id == "" {
+				option.Option_id = primitive.NewObjectID().Hex()
+			}
+			var num float64
+			if option.Price_delta != nil {
+				num = toFixed(*option.Price_delta, 2)
+			}
+			option.Price_delta = &num
+		}
 	}
 }
 
@@ -159,6 +367,7 @@ func UpdateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var food models.Food
 		var menu models.Menu
 		if err := c.BindJSON(&food); err != nil {
@@ -179,13 +388,38 @@ func UpdateFood() gin.HandlerFunc {
 		}
 		if food.Menu_id != nil {
 			err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
-			defer cancel()
 			if err != nil {
 				msg := fmt.Sprintf("message: Menu was not found")
 				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 				return
 			}
-			updateObj = append(updateObj, bson.E{Key: "menu", Value: food.Price})
+			updateObj = append(updateObj, bson.E{Key: "menu_id", Value: food.Menu_id})
+		}
+		if food.Station != nil {
+			updateObj = append(updateObj, bson.E{Key: "station", Value: food.Station})
+		}
+		if food.Portions_remaining != nil {
+			if *food.Portions_remaining < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "portions_remaining cannot be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "portions_remaining", Value: food.Portions_remaining})
+		}
+		if food.Variants != nil {
+			if validationErr := validate.Var(food.Variants, "dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			prepareVariants(food.Variants)
+			updateObj = append(updateObj, bson.E{Key: "variants", Value: food.Variants})
+		}
+		if food.Option_groups != nil {
+			if validationErr := validate.Var(food.Option_groups, "dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			prepareOptionGroups(food.Option_groups)
+			updateObj = append(updateObj, bson.E{Key: "option_groups", Value: food.Option_groups})
 		}
 
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -196,7 +430,7 @@ func UpdateFood() gin.HandlerFunc {
 		opt := options.UpdateOptions{
 			Upsert: &upsert,
 		}
-		result, err := menuCollection.UpdateOne(ctx, filter, bson.D{
+		result, err := foodCollection.UpdateOne(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
 		}, &opt)
 
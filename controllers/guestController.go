@@ -0,0 +1,335 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// guestUserId records guests as the author of the orders they open.
+const guestUserId = "guest"
+
+// ongoingOrderStatuses are the statuses of an order guests may still add to.
+var ongoingOrderStatuses = bson.A{nil, models.OrderStatusOpen, models.OrderStatusFired, models.OrderStatusReady, models.OrderStatusServed}
+
+// StartTableSession issues the token for a table's QR code. Any session
+// previously issued for the table stops working.
+func StartTableSession() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tableId := c.Param("table_id")
+		sessionId := primitive.NewObjectID().Hex()
+
+		token, expiresAt, err := helper.GenerateTableSessionToken(tableId, sessionId)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while starting the table session"})
+			return
+		}
+
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "session_id", Value: sessionId},
+				{Key: "session_expires_at", Value: expiresAt},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while starting the table session"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"table_id":   tableId,
+			"token":      token,
+			"expires_at": expiresAt,
+			"url":        appURL() + "/table?session=" + token,
+		})
+	}
+}
+
+// EndTableSession revokes the QR code session of a table.
+func EndTableSession() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tableId := c.Param("table_id")
+
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "session_id", Value: nil},
+				{Key: "session_expires_at", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while ending the table session"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetGuestOrder shows guests what has been ordered at their table so far,
+// including items still awaiting confirmation.
+func GetGuestOrder() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		order, err := ongoingTableOrder(ctx, c.GetString("table_id"))
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, gin.H{"order_id": nil, "order_items": []models.OrderItem{}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order"})
+			return
+		}
+
+		result, err := orderItemCollection.Find(ctx, bson.M{"order_id": order.Order_id, "parent_order_item_id": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order"})
+			return
+		}
+		orderItems := []models.OrderItem{}
+		if err = result.All(ctx, &orderItems); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"order_id":     order.Order_id,
+			"order_status": orderStatus(order),
+			"order_items":  orderItems,
+		})
+	}
+}
+
+// CreateGuestOrderItems adds items ordered by guests to their table's ongoing
+// order, opening one when there is none. The items wait for staff to confirm
+// them before they are sent to the kitchen or billed.
+func CreateGuestOrderItems() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_items []models.OrderItem `json:"order_items" validate:"required,min=1"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		for i := range body.Order_items {
+			validationErr := validate.StructExcept(body.Order_items[i], "Order_id")
+			if validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			if err := checkNewOrderItem(body.Order_items[i]); err != nil {
+				respondOrderItemError(c, err)
+				return
+			}
+		}
+
+		tableId := c.GetString("table_id")
+		sessionId := c.GetString("session_id")
+
+		insertOrderItems, err := placeOrderItems(ctx, body.Order_items, func() (string, error) {
+			order, err := ongoingTableOrder(ctx, tableId)
+			if err == nil {
+				return order.Order_id, nil
+			}
+			if err != mongo.ErrNoDocuments {
+				return "", err
+			}
+			order.Table_id = &tableId
+			order.Order_Date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			openOrder(&order, guestUserId)
+			return OrderItemOrderCreator(order), nil
+		}, func(orderItem *models.OrderItem) {
+			orderItem.Awaiting_confirmation = true
+			orderItem.Guest_session_id = &sessionId
+		})
+		if err != nil {
+			respondOrderItemError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, insertOrderItems)
+	}
+}
+
+// ConfirmOrderItems accepts the guest items of an order awaiting
+// confirmation, all of them or those listed. Items of an order the kitchen is
+// already working on are sent to it straight away, the others go with the
+// order when it is fired.
+func ConfirmOrderItems() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderId := c.Param("order_id")
+		pending, err := pendingOrderItems(ctx, c, orderId)
+		if err != nil {
+			return
+		}
+
+		ids := bson.A{}
+		for _, orderItem := range pending {
+			ids = append(ids, orderItem.Order_item_id)
+		}
+
+		now := time.Now()
+		Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		filter := bson.M{"order_item_id": bson.M{"$in": ids}, "awaiting_confirmation": true}
+		result, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "awaiting_confirmation", Value: false},
+				{Key: "confirmed_by", Value: c.GetString("user_id")},
+				{Key: "confirmed_at", Value: now},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while confirming order items"})
+			return
+		}
+
+		var order models.Order
+		if err = orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order); err != nil {
+			log.Println(err)
+		} else if orderIsInKitchen(order) {
+			queueOrderItems(ctx, orderId, now)
+
+			cursor, err := orderItemCollection.Find(ctx, bson.M{"order_item_id": bson.M{"$in": ids}})
+			var confirmed []models.OrderItem
+			if err == nil {
+				err = cursor.All(ctx, &confirmed)
+			}
+			if err != nil {
+				log.Println(err)
+			} else {
+				publishKitchenEvents(ctx, models.KitchenEventItemAdded, order, confirmed)
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// RejectOrderItems drops guest items awaiting confirmation, all of them or
+// those listed, and gives their portions back.
+func RejectOrderItems() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		pending, err := pendingOrderItems(ctx, c, c.Param("order_id"))
+		if err != nil {
+			return
+		}
+
+		ids := bson.A{}
+		var released []portionReservation
+		for _, orderItem := range pending {
+			ids = append(ids, orderItem.Order_item_id)
+			if orderItem.Food_id != nil && orderItem.Quantity != nil {
+				released = append(released, portionReservation{Food_id: *orderItem.Food_id, Quantity: *orderItem.Quantity})
+			}
+		}
+
+		result, err := orderItemCollection.DeleteMany(ctx, bson.M{"order_item_id": bson.M{"$in": ids}, "awaiting_confirmation": true})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while rejecting order items"})
+			return
+		}
+		releasePortions(ctx, released)
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// pendingOrderItems loads the items of an order awaiting confirmation that a
+// confirm or reject request is about, together with the components of the
+// bundles among them. It responds itself when it fails.
+func pendingOrderItems(ctx context.Context, c *gin.Context, orderId string) ([]models.OrderItem, error) {
+	var body struct {
+		Order_item_ids []string `json:"order_item_ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, err
+		}
+	}
+
+	filter := bson.M{"order_id": orderId, "awaiting_confirmation": true}
+	if len(body.Order_item_ids) > 0 {
+		filter["$or"] = bson.A{
+			bson.M{"order_item_id": bson.M{"$in": body.Order_item_ids}},
+			bson.M{"parent_order_item_id": bson.M{"$in": body.Order_item_ids}},
+		}
+	}
+
+	result, err := orderItemCollection.Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while fetching order items"})
+		return nil, err
+	}
+	var pending []models.OrderItem
+	if err = result.All(ctx, &pending); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while fetching order items"})
+		return nil, err
+	}
+	if len(pending) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no order items are awaiting confirmation"})
+		return nil, mongo.ErrNoDocuments
+	}
+	return pending, nil
+}
+
+// ongoingTableOrder returns the most recent order of a table that has not
+// been billed, closed or cancelled yet.
+func ongoingTableOrder(ctx context.Context, tableId string) (models.Order, error) {
+	var order models.Order
+	opts := options.FindOne().SetSort(bson.D{{Key: "order_date", Value: -1}})
+	err := orderCollection.FindOne(ctx, bson.M{
+		"table_id":     tableId,
+		"order_status": bson.M{"$in": ongoingOrderStatuses},
+	}, opts).Decode(&order)
+	return order, err
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateGuestOrderItemsRejectsAdjustments(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     gin.H
+		wantCode string
+	}{
+		{name: "comped", item: gin.H{"comped_quantity": 2}, wantCode: "ADJUSTMENT_NOT_ALLOWED"},
+		{name: "voided", item: gin.H{"voided": true, "voided_quantity": 2}, wantCode: "ADJUSTMENT_NOT_ALLOWED"},
+		{name: "off the bill as a bundle component", item: gin.H{"parent_order_item_id": "bundle-line"}, wantCode: "BUNDLE_COMPONENT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := gin.H{"food_id": "food-1", "quantity": 1}
+			for key, value := range tt.item {
+				item[key] = value
+			}
+
+			w := performJSON(CreateGuestOrderItems(), gin.H{"order_items": []gin.H{item}})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			var body struct{ Code string }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"restaurant-management/database"
+	helper "restaurant-management/helpers"
 	"restaurant-management/models"
 	"time"
 
@@ -16,7 +18,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// format
+// format. Payment_due is the balance in major units, the breakdown in minor
+// units of Currency.
 type InvoiceViewFormat struct {
 	Invoice_id       string
 	Payment_method   string
@@ -26,6 +29,19 @@ type InvoiceViewFormat struct {
 	Table_number     interface{}
 	Payment_due_date time.Time
 	Order_details    interface{}
+	Currency         string
+	Tax_mode         string
+	Subtotal         int64
+	Discount         int64
+	Service_charge   int64
+	Taxes            []models.InvoiceTax
+	Tax_total        int64
+	Tip              int64
+	Rounding         int64
+	Total            int64
+	Amount_paid      int64
+	Amount_refunded  int64
+	Balance          int64
 }
 
 var invoiceCollection *mongo.Collection = database.OpenCollection(database.Client, "invoice")
@@ -34,20 +50,22 @@ func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		result, err := invoiceCollection.Find(context.TODO(), bson.M{})
-		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured listing the invoices"})
 			return
 		}
 
-		var allInvoices []bson.M
+		allInvoices := []bson.M{}
 		if err = result.All(ctx, &allInvoices); err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured listing the invoices"})
+			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		c.JSON(http.StatusOK, allInvoices)
 
 	}
 }
@@ -55,19 +73,34 @@ func GetInvoices() gin.HandlerFunc {
 func GetInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		invoiceId := c.Param("invoice_id")
 
 		var invoice models.Invoice
 
 		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
-		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice item"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+
+		// Invoices created before totals were computed get them on the fly.
+		if invoice.Currency == "" {
+			bill, settings, err := billOrder(ctx, invoice.Order_id, invoice.Discount, invoice.Tip)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while computing the invoice"})
+				return
+			}
+			applyBill(&invoice, bill, settings)
 		}
 
 		var invoiceView InvoiceViewFormat
 
 		allOrderItems, err := ItemsByOrder(invoice.Order_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice item"})
+			return
+		}
 		invoiceView.Order_id = invoice.Order_id
 		invoiceView.Payment_due_date = invoice.Payment_due_date
 
@@ -76,106 +109,256 @@ func GetInvoice() gin.HandlerFunc {
 			invoiceView.Payment_method = *invoice.Payment_method
 		}
 
-		invoiceView.Invoice_id = *invoice.Invoice_id
-		invoiceView.Payment_status = *invoice.Payment_status
-		invoiceView.Payment_due = allOrderItems[0]["payment_due"]
-		invoiceView.Table_number = allOrderItems[0]["table_number"]
-		invoiceView.Order_details = allOrderItems[0]["order_items"]
+		invoiceView.Invoice_id = invoice.Invoice_id
+		invoiceView.Payment_status = models.InvoiceStatusPending
+		if invoice.Payment_status != nil {
+			invoiceView.Payment_status = *invoice.Payment_status
+		}
+		invoiceView.Payment_due = helper.FromMinor(invoiceBalance(invoice))
+		invoiceView.Order_details = []interface{}{}
+		if len(allOrderItems) > 0 {
+			invoiceView.Table_number = allOrderItems[0]["table_number"]
+			invoiceView.Order_details = allOrderItems[0]["order_items"]
+			if invoice.Split_id != nil {
+				invoiceView.Order_details = splitOrderDetails(allOrderItems[0]["order_items"], invoice.Order_item_ids)
+			}
+		}
+		invoiceView.Currency = invoice.Currency
+		invoiceView.Tax_mode = invoice.Tax_mode
+		invoiceView.Subtotal = invoice.Subtotal
+		invoiceView.Discount = invoice.Discount
+		invoiceView.Service_charge = invoice.Service_charge
+		invoiceView.Taxes = invoice.Taxes
+		invoiceView.Tax_total = invoice.Tax_total
+		invoiceView.Tip = invoice.Tip
+		invoiceView.Rounding = invoice.Rounding
+		invoiceView.Total = invoice.Total
+		invoiceView.Amount_paid = invoice.Amount_paid
+		invoiceView.Amount_refunded = invoice.Amount_refunded
+		invoiceView.Balance = invoiceBalance(invoice)
 
 		c.JSON(http.StatusOK, invoiceView)
 	}
 }
 
+// CreateInvoice bills an order on one invoice. An order is invoiced once:
+// to bill it between several payers it is split with SplitInvoices instead,
+// and cancelled or voided orders are not billed.
 func CreateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var invoice models.Invoice
+		defer cancel()
+		var body struct {
+			Order_id         string    `json:"order_id" validate:"required"`
+			Payment_method   *string   `json:"payment_method" validate:"omitempty,eq=CARD|eq=CASH"`
+			Payment_due_date time.Time `json:"payment_due_date"`
+			Discount         int64     `json:"discount" validate:"min=0"`
+			Tip              int64     `json:"tip" validate:"min=0"`
+		}
 		var order models.Order
-		if err := c.BindJSON(&invoice); err != nil {
+		if err := c.BindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			return
 		}
-		validationErr := validate.Struct(invoice)
+		validationErr := validate.Struct(body)
 
 		if validationErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
-		err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
-		defer cancel()
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": body.Order_id}).Decode(&order)
 
 		if err != nil {
 			msg := fmt.Sprintf("Order was not Found")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		bill, settings, err := billOrder(ctx, body.Order_id, body.Discount, body.Tip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while computing the invoice"})
+			return
+		}
+
+		invoice := models.Invoice{
+			Order_id:         body.Order_id,
+			Payment_method:   body.Payment_method,
+			Payment_due_date: body.Payment_due_date,
+			Discount:         body.Discount,
+			Tip:              body.Tip,
+		}
+		applyBill(&invoice, bill, settings)
+		status := models.InvoiceStatusPending
+		invoice.Payment_status = &status
+
 		invoice.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		invoice.ID = primitive.NewObjectID()
 		invoice.Invoice_id = invoice.ID.Hex()
 
-		result, resultErr := invoiceCollection.InsertOne(ctx, invoice)
+		resultErr := withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			if err := lockOrderInvoices(sessCtx, body.Order_id); err != nil {
+				return err
+			}
+			if err := orderCollection.FindOne(sessCtx, bson.M{"order_id": body.Order_id}).Decode(&order); err != nil {
+				return err
+			}
+			if status := orderStatus(order); status == models.OrderStatusCancelled || status == models.OrderStatusVoid {
+				return &invoiceError{Status: http.StatusConflict, Code: "ORDER_NOT_BILLABLE", Message: fmt.Sprintf("the order is %s", status)}
+			}
+			count, err := invoiceCollection.CountDocuments(sessCtx, bson.M{"order_id": body.Order_id})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return &invoiceError{Status: http.StatusConflict, Code: "ORDER_ALREADY_INVOICED", Message: "the order already has invoices"}
+			}
+			_, err = invoiceCollection.InsertOne(sessCtx, invoice)
+			return err
+		})
 
 		if resultErr != nil {
-			msg := "Invoice was not Created"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			respondInvoiceError(c, resultErr)
 			return
 
 		}
-		defer cancel()
 
-		c.JSON(http.StatusOK, result)
+		c.JSON(http.StatusOK, invoice)
 
 	}
 }
 
+// lockOrderInvoices writes the invoice lock of an order. Every transaction
+// that creates or replaces the invoices of an order writes it first, so two
+// of them working on the same order conflict instead of both billing it.
+func lockOrderInvoices(ctx context.Context, orderId string) error {
+	_, err := orderCollection.UpdateOne(ctx, bson.M{"order_id": orderId}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "invoice_lock", Value: 1}}},
+	})
+	return err
+}
+
+// UpdateInvoice changes the payment method, discount or tip of an invoice.
+// The totals of an invoice nothing was paid on yet are recomputed from its
+// order, so they follow items added since it was created; once payments are
+// recorded they are final. The payment status follows the payments.
 func UpdateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		invoiceId := c.Param("invoice_id")
-		var invoice models.Invoice
-		// var order models.Order
-		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
 		defer cancel()
-		if err != nil {
+		invoiceId := c.Param("invoice_id")
+		var body struct {
+			Payment_method *string `json:"payment_method" validate:"omitempty,eq=CARD|eq=CASH"`
+			Payment_status *string `json:"payment_status"`
+			Discount       *int64  `json:"discount" validate:"omitempty,min=0"`
+			Tip            *int64  `json:"tip" validate:"omitempty,min=0"`
+		}
+		var foundInvoice models.Invoice
+		if err := c.BindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		filter := bson.M{"invoice_id": invoiceId}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&foundInvoice)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+		if body.Payment_status != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the payment status follows the payments recorded on the invoice", "code": "RECORD_A_PAYMENT"})
+			return
+		}
+		paid := foundInvoice.Payment_status != nil && *foundInvoice.Payment_status != models.InvoiceStatusPending
+		if paid && (body.Discount != nil || body.Tip != nil) {
+			c.JSON(http.StatusConflict, gin.H{"error": "payments were already recorded on the invoice", "code": "INVOICE_PAID"})
+			return
+		}
+		split := foundInvoice.Split_id != nil
+		if split && (body.Discount != nil || body.Tip != nil) {
+			c.JSON(http.StatusConflict, gin.H{"error": "split the order again to change its discount or tip", "code": "INVOICE_SPLIT"})
+			return
+		}
 
 		var updateObj primitive.D
 
-		// status := "PENDING"
-
-		if invoice.Payment_status != nil {
-			updateObj = append(updateObj, bson.E{Key: "payment_status", Value: invoice.Payment_status})
+		if body.Payment_method != nil {
+			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: body.Payment_method})
 
 		}
-		if invoice.Payment_method != nil {
-			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: invoice.Payment_method})
 
+		if !paid && !split {
+			discount, tip := foundInvoice.Discount, foundInvoice.Tip
+			if body.Discount != nil {
+				discount = *body.Discount
+			}
+			if body.Tip != nil {
+				tip = *body.Tip
+			}
+			bill, settings, err := billOrder(ctx, foundInvoice.Order_id, discount, tip)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while computing the invoice"})
+				return
+			}
+			applyBill(&foundInvoice, bill, settings)
+			updateObj = append(updateObj, billFields(foundInvoice)...)
 		}
 
-		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: invoice.Updated_at})
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: Updated_at})
 
-		upsert := true
+		// Recomputed totals are only written if no payment came in meanwhile.
+		filter := bson.M{"invoice_id": invoiceId, "payment_status": foundInvoice.Payment_status}
 
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
-		}
-		result, err := invoiceCollection.UpdateOne(ctx, filter, bson.D{
+		var updated models.Invoice
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
-		}, &opt)
+		}, opts).Decode(&updated)
 
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice was paid meanwhile, try again", "code": "INVOICE_CHANGED"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error Occured while updating Invoice"})
 			return
 		}
-		defer cancel()
 
-		c.JSON(http.StatusOK, result)
+		c.JSON(http.StatusOK, updated)
+
+	}
+}
+
+// splitOrderDetails keeps the order items an invoice of a split pays for.
+func splitOrderDetails(orderItems interface{}, orderItemIds []string) []interface{} {
+	billed := map[string]bool{}
+	for _, orderItemId := range orderItemIds {
+		billed[orderItemId] = true
+	}
+
+	details := []interface{}{}
+	items, _ := orderItems.(primitive.A)
+	for _, item := range items {
+		detail, ok := item.(primitive.M)
+		if doc, isD := item.(primitive.D); isD {
+			detail, ok = doc.Map(), true
+		}
+		if ok && billed[fmt.Sprint(detail["order_item_id"])] {
+			details = append(details, detail)
+		}
+	}
+	return details
+}
 
+// invoiceBalance is what is left to pay on an invoice. Invoices marked paid
+// before payments were recorded have nothing left to pay.
+func invoiceBalance(invoice models.Invoice) int64 {
+	if invoice.Payment_status != nil && *invoice.Payment_status == models.InvoiceStatusPaid {
+		return 0
 	}
+	return invoice.Total - invoice.Amount_paid
 }
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"restaurant-management/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertTestOrder stores an order with status and removes it and its
+// invoices when the test ends.
+func insertTestOrder(t *testing.T, status string) models.Order {
+	t.Helper()
+	return insertTestOrderAt(t, status, primitive.NewObjectID().Hex())
+}
+
+// insertTestOrderAt is insertTestOrder for an order at table tableId.
+func insertTestOrderAt(t *testing.T, status string, tableId string) models.Order {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	order := models.Order{ID: primitive.NewObjectID(), Order_status: &status, Table_id: &tableId}
+	order.Order_id = order.ID.Hex()
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		orderCollection.DeleteOne(context.Background(), bson.M{"order_id": order.Order_id})
+		invoiceCollection.DeleteMany(context.Background(), bson.M{"order_id": order.Order_id})
+	})
+	return order
+}
+
+func TestCreateInvoice(t *testing.T) {
+	requireTransactions(t)
+
+	tests := []struct {
+		name       string
+		status     string
+		existing   bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "ongoing order", status: models.OrderStatusServed, wantStatus: http.StatusOK},
+		{name: "order already invoiced", status: models.OrderStatusServed, existing: true, wantStatus: http.StatusConflict, wantCode: "ORDER_ALREADY_INVOICED"},
+		{name: "cancelled order", status: models.OrderStatusCancelled, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_BILLABLE"},
+		{name: "voided order", status: models.OrderStatusVoid, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_BILLABLE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := insertTestOrder(t, tt.status)
+			if tt.existing {
+				if w := performJSON(CreateInvoice(), gin.H{"order_id": order.Order_id}); w.Code != http.StatusOK {
+					t.Fatalf("first invoice: status %d: %s", w.Code, w.Body)
+				}
+			}
+
+			// Fields the server keeps track of are ignored.
+			w := performJSON(CreateInvoice(), gin.H{
+				"order_id":              order.Order_id,
+				"tip":                   200,
+				"amount_paid":           5000,
+				"amount_refunded":       5000,
+				"split_id":              "split-1",
+				"order_item_ids":        []string{"item-1"},
+				"provider_transactions": []gin.H{{"transaction_id": "txn-1"}},
+			})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				var body struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+				}
+			}
+
+			count, err := invoiceCollection.CountDocuments(context.Background(), bson.M{"order_id": order.Order_id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantCount := int64(0)
+			if tt.existing || tt.wantStatus == http.StatusOK {
+				wantCount = 1
+			}
+			if count != wantCount {
+				t.Fatalf("%d invoices, want %d", count, wantCount)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var invoice models.Invoice
+			if err = invoiceCollection.FindOne(context.Background(), bson.M{"order_id": order.Order_id}).Decode(&invoice); err != nil {
+				t.Fatal(err)
+			}
+			if invoice.Tip != 200 || invoice.Amount_paid != 0 || invoice.Amount_refunded != 0 || invoice.Split_id != nil || len(invoice.Order_item_ids) != 0 || len(invoice.Provider_transactions) != 0 {
+				t.Errorf("invoice stored as sent: %+v", invoice)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// invoiceError is returned when an invoice cannot be created or changed,
+// with the status and code to answer with.
+type invoiceError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *invoiceError) Error() string {
+	return e.Message
+}
+
+func respondInvoiceError(c *gin.Context, err error) {
+	var invoiceErr *invoiceError
+	if errors.As(err, &invoiceErr) {
+		c.JSON(invoiceErr.Status, gin.H{"error": invoiceErr.Message, "code": invoiceErr.Code})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while saving the invoice"})
+}
+
+// SplitInvoices bills an order on several invoices: by the items each payer
+// takes, by seat, or in equal shares. An item may be listed for several
+// payers, who then share it evenly; by seat, items without a seat are shared
+// by every seat. The invoices add up exactly to the bill of the whole order
+// and replace its unpaid invoices.
+func SplitInvoices() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_id         string     `json:"order_id" validate:"required"`
+			Mode             string     `json:"mode" validate:"required,eq=ITEMS|eq=SEATS|eq=EQUAL"`
+			Shares           int        `json:"shares" validate:"required_if=Mode EQUAL,omitempty,min=2,max=50"`
+			Items            [][]string `json:"items" validate:"required_if=Mode ITEMS,omitempty,min=2,dive,min=1"`
+			Discount         int64      `json:"discount" validate:"min=0"`
+			Tip              int64      `json:"tip" validate:"min=0"`
+			Payment_due_date time.Time  `json:"payment_due_date"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if count, err := orderCollection.CountDocuments(ctx, bson.M{"order_id": body.Order_id}); err != nil || count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order was not found"})
+			return
+		}
+
+		input, items, settings, err := orderBillInput(ctx, body.Order_id, body.Discount, body.Tip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while computing the invoice"})
+			return
+		}
+		if len(items) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order has nothing to bill", "code": "NOTHING_TO_BILL"})
+			return
+		}
+
+		var shares [][]int
+		switch body.Mode {
+		case models.SplitEqually:
+			shares = equalShares(len(items), body.Shares)
+		case models.SplitBySeats:
+			shares, err = seatShares(items)
+		case models.SplitByItems:
+			shares, err = itemShares(items, body.Items)
+		}
+		if err != nil {
+			respondInvoiceError(c, err)
+			return
+		}
+
+		bill := helper.ComputeBill(input)
+		splitId := primitive.NewObjectID().Hex()
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		invoices := make([]models.Invoice, len(shares))
+		for i, share := range helper.SplitBill(input, bill, shares) {
+			status := models.InvoiceStatusPending
+			invoice := models.Invoice{
+				Order_id:         body.Order_id,
+				Payment_status:   &status,
+				Payment_due_date: body.Payment_due_date,
+				Split_id:         &splitId,
+				Split_mode:       &body.Mode,
+				Order_item_ids:   []string{},
+				Created_at:       now,
+				Updated_at:       now,
+			}
+			applyBill(&invoice, share, settings)
+			for _, line := range shares[i] {
+				invoice.Order_item_ids = append(invoice.Order_item_ids, items[line].Order_item_id)
+			}
+			invoice.ID = primitive.NewObjectID()
+			invoice.Invoice_id = invoice.ID.Hex()
+			invoices[i] = invoice
+		}
+
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			return replaceUnpaidInvoices(sessCtx, body.Order_id, invoices)
+		})
+		if err != nil {
+			respondInvoiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"total": bill.Total, "currency": settings.Currency, "invoices": invoices})
+	}
+}
+
+// replaceUnpaidInvoices swaps the pending invoices of an order for new ones.
+// An order with an invoice already paid, even partly, is not split again.
+func replaceUnpaidInvoices(ctx context.Context, orderId string, invoices []models.Invoice) error {
+	if err := lockOrderInvoices(ctx, orderId); err != nil {
+		return err
+	}
+	count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "payment_status": bson.M{"$ne": models.InvoiceStatusPending}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &invoiceError{Status: http.StatusConflict, Code: "ORDER_ALREADY_PAID", Message: "the order already has paid invoices"}
+	}
+	if _, err = invoiceCollection.DeleteMany(ctx, bson.M{"order_id": orderId}); err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, len(invoices))
+	for i, invoice := range invoices {
+		documents[i] = invoice
+	}
+	_, err = invoiceCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// equalShares splits every line over n shares.
+func equalShares(lines int, n int) [][]int {
+	shares := make([][]int, n)
+	for i := range shares {
+		for line := 0; line < lines; line++ {
+			shares[i] = append(shares[i], line)
+		}
+	}
+	return shares
+}
+
+// seatShares gives every seat a share with its items, in seat order. Items
+// without a seat are shared by all seats.
+func seatShares(items []models.OrderItem) ([][]int, error) {
+	bySeat := map[int][]int{}
+	var shared []int
+	for line, item := range items {
+		if item.Seat == nil {
+			shared = append(shared, line)
+			continue
+		}
+		bySeat[*item.Seat] = append(bySeat[*item.Seat], line)
+	}
+	if len(bySeat) == 0 {
+		return nil, &invoiceError{Status: http.StatusConflict, Code: "NO_SEATS", Message: "no item of the order has a seat"}
+	}
+
+	seats := make([]int, 0, len(bySeat))
+	for seat := range bySeat {
+		seats = append(seats, seat)
+	}
+	sort.Ints(seats)
+
+	shares := make([][]int, len(seats))
+	for i, seat := range seats {
+		shares[i] = append(bySeat[seat], shared...)
+		sort.Ints(shares[i])
+	}
+	return shares, nil
+}
+
+// itemShares maps the order item ids each payer takes to line indexes. Every
+// billable item must be taken by someone.
+func itemShares(items []models.OrderItem, groups [][]string) ([][]int, error) {
+	lineOf := map[string]int{}
+	for line, item := range items {
+		lineOf[item.Order_item_id] = line
+	}
+
+	taken := make([]bool, len(items))
+	shares := make([][]int, len(groups))
+	for i, group := range groups {
+		inShare := map[int]bool{}
+		for _, orderItemId := range group {
+			line, ok := lineOf[orderItemId]
+			if !ok {
+				return nil, &invoiceError{Status: http.StatusBadRequest, Code: "ORDER_ITEM_NOT_FOUND", Message: fmt.Sprintf("order item %s is not billable on this order", orderItemId)}
+			}
+			if inShare[line] {
+				continue
+			}
+			inShare[line] = true
+			taken[line] = true
+			shares[i] = append(shares[i], line)
+		}
+	}
+
+	for line, ok := range taken {
+		if !ok {
+			return nil, &invoiceError{Status: http.StatusBadRequest, Code: "ITEM_NOT_ASSIGNED", Message: fmt.Sprintf("order item %s is not assigned to any invoice", items[line].Order_item_id)}
+		}
+	}
+	return shares, nil
+}
@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"log"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var kitchenEventCollection *mongo.Collection = database.OpenCollection(database.Client, "kitchenEvent")
+var counterCollection *mongo.Collection = database.OpenCollection(database.Client, "counter")
+
+// kitchenHeartbeat is how often an idle stream sends a comment so proxies
+// and tablets do not drop the connection.
+const kitchenHeartbeat = 15 * time.Second
+
+// kitchenHub fans kitchen events out to the streams connected to this instance.
+type kitchenHub struct {
+	mu          sync.Mutex
+	subscribers map[chan models.KitchenEvent]struct{}
+}
+
+var kitchenFeed = &kitchenHub{subscribers: map[chan models.KitchenEvent]struct{}{}}
+
+// kitchenEventMu serializes allocating, storing and publishing kitchen
+// events so the streams of this instance receive them in event id order.
+var kitchenEventMu sync.Mutex
+
+func (h *kitchenHub) subscribe() chan models.KitchenEvent {
+	ch := make(chan models.KitchenEvent, 64)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *kitchenHub) unsubscribe(ch chan models.KitchenEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish never blocks: a subscriber that cannot keep up is disconnected and
+// catches up from the event log when it reconnects with its last event id.
+func (h *kitchenHub) publish(event models.KitchenEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// KitchenStream streams kitchen events as Server-Sent Events. The station
+// query parameter limits the feed to one kitchen station; items without a
+// station are sent to every station. A client reconnecting with the
+// Last-Event-ID header (or the last_event_id query parameter) first receives
+// every event it missed.
+func KitchenStream() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		station := c.Query("station")
+
+		lastEventId := c.GetHeader("Last-Event-ID")
+		if lastEventId == "" {
+			lastEventId = c.Query("last_event_id")
+		}
+		lastId, _ := strconv.ParseInt(lastEventId, 10, 64)
+
+		// Subscribe before replaying so nothing published in between is lost.
+		events := kitchenFeed.subscribe()
+		defer kitchenFeed.unsubscribe(events)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+
+		if lastId > 0 {
+			var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
+			missed, err := kitchenEventsSince(ctx, lastId, station)
+			cancel()
+			if err != nil {
+				log.Println(err)
+			}
+			for _, event := range missed {
+				renderKitchenEvent(c, event)
+				lastId = event.Event_id
+			}
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(kitchenHeartbeat)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-heartbeat.C:
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err == nil
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				if event.Event_id <= lastId {
+					return true
+				}
+				// A gap means events were published elsewhere or out of
+				// order: the log is the reference, and already holds this
+				// event since events are stored before they are published.
+				if lastId > 0 && event.Event_id > lastId+1 {
+					var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
+					missed, err := kitchenEventsSince(ctx, lastId, station)
+					cancel()
+					if err != nil {
+						log.Println(err)
+						return false
+					}
+					for _, missedEvent := range missed {
+						renderKitchenEvent(c, missedEvent)
+						lastId = missedEvent.Event_id
+					}
+				} else if forStation(event, station) {
+					renderKitchenEvent(c, event)
+				}
+				if event.Event_id > lastId {
+					lastId = event.Event_id
+				}
+				return true
+			}
+		})
+	}
+}
+
+func renderKitchenEvent(c *gin.Context, event models.KitchenEvent) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatInt(event.Event_id, 10),
+		Event: event.Type,
+		Retry: 3000,
+		Data:  event,
+	})
+}
+
+func forStation(event models.KitchenEvent, station string) bool {
+	return station == "" || event.Station == nil || *event.Station == station
+}
+
+func kitchenEventsSince(ctx context.Context, lastId int64, station string) ([]models.KitchenEvent, error) {
+	filter := bson.M{"event_id": bson.M{"$gt": lastId}}
+	if station != "" {
+		filter["$or"] = []bson.M{{"station": station}, {"station": nil}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "event_id", Value: 1}})
+	result, err := kitchenEventCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []models.KitchenEvent
+	err = result.All(ctx, &events)
+	return events, err
+}
+
+// nextSequence returns the next value of the named counter.
+func nextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := counterCollection.FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "seq", Value: 1}}},
+	}, opts).Decode(&counter)
+	return counter.Seq, err
+}
+
+// publishKitchenEvents records one event per order item and pushes them to
+// the connected kitchen displays.
+func publishKitchenEvents(ctx context.Context, eventType string, order models.Order, orderItems []models.OrderItem) {
+	foods := map[string]models.Food{}
+
+	for _, orderItem := range orderItems {
+		// A bundle line is billed, its components are cooked. Guest items
+		// wait for staff to confirm them.
+		if orderItem.Bundle_id != nil || orderItem.Awaiting_confirmation {
+			continue
+		}
+		var event models.KitchenEvent
+
+		if orderItem.Food_id != nil {
+			food, ok := foods[*orderItem.Food_id]
+			if !ok {
+				if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.Food_id}).Decode(&food); err != nil {
+					log.Println(err)
+				}
+				foods[*orderItem.Food_id] = food
+			}
+			event.Station = food.Station
+			event.Food_name = food.Name
+		}
+
+		kitchenEventMu.Lock()
+		eventId, err := nextSequence(ctx, "kitchenEvent")
+		if err != nil {
+			kitchenEventMu.Unlock()
+			log.Println(err)
+			continue
+		}
+
+		event.ID = primitive.NewObjectID()
+		event.Event_id = eventId
+		event.Type = eventType
+		event.Order_id = order.Order_id
+		event.Table_id = order.Table_id
+		event.Order_item = orderItem
+		event.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if _, err = kitchenEventCollection.InsertOne(ctx, event); err != nil {
+			kitchenEventMu.Unlock()
+			log.Println(err)
+			continue
+		}
+		kitchenFeed.publish(event)
+		kitchenEventMu.Unlock()
+	}
+}
+
+// orderIsInKitchen reports whether an order has been fired and not yet
+// cancelled, i.e. whether the kitchen knows about its items.
+func orderIsInKitchen(order models.Order) bool {
+	switch orderStatus(order) {
+	case models.OrderStatusFired, models.OrderStatusReady, models.OrderStatusServed:
+		return true
+	}
+	return false
+}
+
+// publishIfFired publishes events for items of an order the kitchen is
+// already working on. Items of an open order reach the kitchen when it is fired.
+func publishIfFired(ctx context.Context, eventType string, orderId string, orderItems []models.OrderItem) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order); err != nil {
+		log.Println(err)
+		return
+	}
+	if orderIsInKitchen(order) {
+		publishKitchenEvents(ctx, eventType, order, orderItems)
+	}
+}
+
+// publishOrderItems publishes an event for every item of an order.
+func publishOrderItems(ctx context.Context, eventType string, order models.Order) {
+	result, err := orderItemCollection.Find(ctx, bson.M{"order_id": order.Order_id, "bundle_id": nil, "awaiting_confirmation": bson.M{"$ne": true}, "voided": bson.M{"$ne": true}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var orderItems []models.OrderItem
+	if err = result.All(ctx, &orderItems); err != nil {
+		log.Println(err)
+		return
+	}
+	publishKitchenEvents(ctx, eventType, order, orderItems)
+}
+
+// queueOrderItems puts the confirmed items of a fired order that are not yet
+// in the kitchen queue into it.
+func queueOrderItems(ctx context.Context, orderId string, now time.Time) {
+	Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	_, err := orderItemCollection.UpdateMany(ctx, bson.M{"order_id": orderId, "item_status": nil, "bundle_id": nil, "awaiting_confirmation": bson.M{"$ne": true}, "voided": bson.M{"$ne": true}}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "item_status", Value: models.ItemStatusQueued},
+			{Key: "queued_at", Value: now},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
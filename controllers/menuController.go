@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"restaurant-management/database"
 	"restaurant-management/models"
 	"time"
@@ -23,33 +24,52 @@ func GetMenus() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		result, err := menuCollection.Find(context.TODO(), bson.M{})
 
-		defer cancel()
-
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "no menu found"})
+			return
 		}
 		var allMenu []bson.M
 		if err = result.All(ctx, &allMenu); err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "no menu found"})
+			return
 		}
+		c.JSON(http.StatusOK, allMenu)
 
 	}
 }
+
+// GetAvailableMenus lists the menus being served right now.
+func GetAvailableMenus() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		menus, err := availableMenus(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the available menus"})
+			return
+		}
+		c.JSON(http.StatusOK, menus)
+	}
+}
 func GetMenu() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		menuId := c.Param("menu_id")
 		var menu models.Menu
 
 		err := menuCollection.FindOne(ctx, bson.M{"menu_id": menuId}).Decode(&menu)
 
-		defer cancel()
-
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the Menu"})
 		}
@@ -64,10 +84,12 @@ func CreateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var menu models.Menu
 		if err := c.BindJSON(&menu); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			return
 		}
 		validationErr := validate.Struct(menu)
 
@@ -75,8 +97,12 @@ func CreateMenu() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+		if menu.Start_Date != nil && menu.End_Date != nil && !menu.End_Date.After(*menu.Start_Date) {
+			msg := "kindly retype the time"
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
 		//err:=foodCollection.FindOne(ctx,bson.M{"food_id":menu.Food_id}).Decode(&food)
-		defer cancel()
 		menu.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
@@ -88,25 +114,146 @@ func CreateMenu() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
-		defer cancel()
 		c.JSON(http.StatusOK, result)
-		defer cancel()
 
 	}
 }
 
 func inTimeSpan(start, end, check time.Time) bool {
-	return start.After(time.Now()) && end.After(start)
+	return !check.Before(start) && check.Before(end)
+}
+
+// restaurantLocation is the timezone menu schedules are written in, taken
+// from RESTAURANT_TIMEZONE and defaulting to the server's local time.
+var restaurantLocation = loadRestaurantLocation()
+
+func loadRestaurantLocation() *time.Location {
+	name := os.Getenv("RESTAURANT_TIMEZONE")
+	if name == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown RESTAURANT_TIMEZONE %q, using local time", name)
+		return time.Local
+	}
+	return location
+}
+
+var weekdays = [...]string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// menuIsActive reports whether a menu is served at the given instant: within
+// its start and end dates, when set, and within one of its schedules, when
+// it has any.
+func menuIsActive(menu models.Menu, check time.Time) bool {
+	switch {
+	case menu.Start_Date != nil && menu.End_Date != nil:
+		if !inTimeSpan(*menu.Start_Date, *menu.End_Date, check) {
+			return false
+		}
+	case menu.Start_Date != nil:
+		if check.Before(*menu.Start_Date) {
+			return false
+		}
+	case menu.End_Date != nil:
+		if !check.Before(*menu.End_Date) {
+			return false
+		}
+	}
+	if len(menu.Schedules) == 0 {
+		return true
+	}
+
+	local := check.In(restaurantLocation)
+	minute := local.Hour()*60 + local.Minute()
+	today := weekdays[local.Weekday()]
+	yesterday := weekdays[(local.Weekday()+6)%7]
+
+	for _, schedule := range menu.Schedules {
+		start, errStart := time.Parse("15:04", schedule.Start)
+		end, errEnd := time.Parse("15:04", schedule.End)
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+		startMinute := start.Hour()*60 + start.Minute()
+		endMinute := end.Hour()*60 + end.Minute()
+
+		if startMinute < endMinute {
+			if scheduledOn(schedule, today) && minute >= startMinute && minute < endMinute {
+				return true
+			}
+			continue
+		}
+		// overnight window
+		if scheduledOn(schedule, today) && minute >= startMinute {
+			return true
+		}
+		if scheduledOn(schedule, yesterday) && minute < endMinute {
+			return true
+		}
+	}
+	return false
+}
+
+func scheduledOn(schedule models.MenuSchedule, day string) bool {
+	for _, scheduled := range schedule.Days {
+		if scheduled == day {
+			return true
+		}
+	}
+	return false
+}
+
+// availableMenus returns the menus active at the given instant.
+func availableMenus(ctx context.Context, check time.Time) ([]models.Menu, error) {
+	result, err := menuCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var allMenus []models.Menu
+	if err = result.All(ctx, &allMenus); err != nil {
+		return nil, err
+	}
+
+	menus := []models.Menu{}
+	for _, menu := range allMenus {
+		if menuIsActive(menu, check) {
+			menus = append(menus, menu)
+		}
+	}
+	return menus, nil
+}
+
+// checkMenuAvailable rejects order items taken from a menu that is not
+// being served right now.
+func checkMenuAvailable(ctx context.Context, menuId *string) error {
+	if menuId == nil {
+		return nil
+	}
+	var menu models.Menu
+	err := menuCollection.FindOne(ctx, bson.M{"menu_id": menuId}).Decode(&menu)
+	if err == mongo.ErrNoDocuments {
+		return &orderItemError{Status: http.StatusNotFound, Code: "MENU_NOT_FOUND", Message: "Menu was not Found"}
+	}
+	if err != nil {
+		return err
+	}
+	if !menuIsActive(menu, time.Now()) {
+		return &orderItemError{Status: http.StatusConflict, Code: "MENU_NOT_AVAILABLE", Message: fmt.Sprintf("%s is not being served right now", menu.Name)}
+	}
+	return nil
 }
 
 func UpdateMenu() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var menu models.Menu
 
 		if err := c.BindJSON(&menu); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			return
 		}
 
 		menuId := c.Param("menu_id")
@@ -114,16 +261,24 @@ func UpdateMenu() gin.HandlerFunc {
 
 		var updateObj primitive.D
 
-		if menu.Start_Date != nil && menu.End_Date != nil {
-			if !inTimeSpan(*menu.Start_Date, *menu.End_Date, time.Now()) {
-				msg := "kindly retype the time"
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-				defer cancel()
+		if menu.Start_Date != nil && menu.End_Date != nil && !menu.End_Date.After(*menu.Start_Date) {
+			msg := "kindly retype the time"
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if menu.Start_Date != nil {
+			updateObj = append(updateObj, bson.E{Key: "start_date", Value: menu.Start_Date})
+		}
+		if menu.End_Date != nil {
+			updateObj = append(updateObj, bson.E{Key: "end_date", Value: menu.End_Date})
+		}
+		if menu.Schedules != nil {
+			if validationErr := validate.Var(menu.Schedules, "dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 				return
 			}
+			updateObj = append(updateObj, bson.E{Key: "schedules", Value: menu.Schedules})
 		}
-		updateObj = append(updateObj, bson.E{Key: "start_date", Value: menu.Start_Date})
-		updateObj = append(updateObj, bson.E{Key: "end_date", Value: menu.End_Date})
 
 		if menu.Name != "" {
 			updateObj = append(updateObj, bson.E{Key: "name", Value: menu.Name})
@@ -147,7 +302,6 @@ func UpdateMenu() gin.HandlerFunc {
 
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 		}
-		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
 }
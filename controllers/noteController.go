@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/middleware"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var noteCollection *mongo.Collection = database.OpenCollection(database.Client, "note")
+
+// GetNotes lists notes, newest first. The order_id, table_id, food_id, shift
+// and created_by query parameters narrow the list down to one entity.
+func GetNotes() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"order_id", "table_id", "food_id", "shift", "created_by"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		result, err := noteCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
+		}
+
+		allNotes := []bson.M{}
+		if err = result.All(ctx, &allNotes); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allNotes)
+	}
+}
+
+func GetNote() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		noteId := c.Param("note_id")
+
+		var note models.Note
+		err := noteCollection.FindOne(ctx, bson.M{"note_id": noteId}).Decode(&note)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+func CreateNote() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var note models.Note
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(note)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if note.Order_id != nil {
+			if count, err := orderCollection.CountDocuments(ctx, bson.M{"order_id": note.Order_id}); err != nil || count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order was not found"})
+				return
+			}
+		}
+		if note.Table_id != nil {
+			if count, err := tableCollection.CountDocuments(ctx, bson.M{"table_id": note.Table_id}); err != nil || count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Table was not found"})
+				return
+			}
+		}
+		if note.Food_id != nil {
+			if count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": note.Food_id}); err != nil || count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Food was not found"})
+				return
+			}
+		}
+
+		note.Created_by = c.GetString("user_id")
+		note.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.ID = primitive.NewObjectID()
+		note.Note_id = note.ID.Hex()
+
+		result, insertErr := noteCollection.InsertOne(ctx, note)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// UpdateNote edits the text of a note. Only its author or a manager may do so.
+func UpdateNote() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var note models.Note
+		var foundNote models.Note
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		noteId := c.Param("note_id")
+
+		err := noteCollection.FindOne(ctx, bson.M{"note_id": noteId}).Decode(&foundNote)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "note was not found"})
+			return
+		}
+		if foundNote.Created_by != c.GetString("user_id") && !middleware.HasRole(c, models.RoleOwner, models.RoleManager) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author of a note may edit it"})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if note.Title != "" {
+			updateObj = append(updateObj, bson.E{Key: "title", Value: note.Title})
+		}
+		if note.Text != "" {
+			updateObj = append(updateObj, bson.E{Key: "text", Value: note.Text})
+		}
+		if note.Shift != nil {
+			updateObj = append(updateObj, bson.E{Key: "shift", Value: note.Shift})
+		}
+
+		note.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: note.Updated_at})
+
+		result, err := noteCollection.UpdateOne(ctx, bson.M{"note_id": noteId}, bson.D{
+			{Key: "$set", Value: updateObj},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -2,10 +2,12 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"restaurant-management/database"
+	"restaurant-management/middleware"
 	"restaurant-management/models"
 	"time"
 
@@ -14,7 +16,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/x/mongo/driver/mongocrypt/options"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
@@ -25,9 +27,9 @@ func GetOrders() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		result, err := orderCollection.Find(context.TODO(), bson.M{})
-		defer cancel()
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while feteching order Items"})
@@ -47,11 +49,11 @@ func GetOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		orderId := c.Param("order_id")
 
 		var order models.Order
 		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
-		defer cancel()
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order item"})
@@ -66,6 +68,7 @@ func CreateOrder() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var order models.Order
 		var table models.Table
 		if err := c.BindJSON(&order); err != nil {
@@ -76,8 +79,7 @@ func CreateOrder() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
-		err := orderCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-		defer cancel()
+		err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
 		if err != nil {
 			msg := fmt.Sprintf("Table was not Found")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
@@ -85,11 +87,11 @@ func CreateOrder() gin.HandlerFunc {
 		}
 		order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		openOrder(&order, c.GetString("user_id"))
 
 		order.ID = primitive.NewObjectID()
 		order.Order_id = order.ID.Hex()
-		result, insertErr := foodCollection.InsertOne(ctx, order)
-		defer cancel()
+		result, insertErr := orderCollection.InsertOne(ctx, order)
 
 		if insertErr != nil {
 			msg := fmt.Sprintf("Create Order falied")
@@ -97,67 +99,202 @@ func CreateOrder() gin.HandlerFunc {
 			return
 
 		}
+		syncTableStatus(ctx, order)
 		c.JSON(http.StatusOK, result)
 
 	}
 }
 
+// UpdateOrder changes an order. Moving it to another table goes through the
+// same checks as a transfer.
 func UpdateOrder() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 		var order models.Order
-		var table models.Table
 		if err := c.BindJSON(&order); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			return
 		}
 		orderId := c.Param("order_id")
 
-		var updateObj primitive.D
-		if order.Table_id != nil {
-			err := orderCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-			defer cancel()
-			if err != nil {
-				msg := fmt.Sprintf("Error Table is not found")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		found, err := movableOrder(ctx, orderId)
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		if order.Table_id != nil && (found.Table_id == nil || *order.Table_id != *found.Table_id) {
+			if found, err = transferOrder(ctx, found, *order.Table_id); err != nil {
+				respondTableError(c, err)
 				return
 			}
 		}
-		updateObj = append(updateObj, bson.E{Key: "table_id", Value: order.Table_id})
-		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: order.Updated_at})
+		c.JSON(http.StatusOK, found)
+
+	}
+}
+
+// UpdateOrderStatus moves an order to the requested status, provided the
+// transition is allowed from its current status.
+func UpdateOrderStatus() gin.HandlerFunc {
 
-		upsert := true
-		filter := bson.M{"order_id": orderId}
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderId := c.Param("order_id")
+
+		var body struct {
+			Status *string `json:"status" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if _, ok := models.OrderStatusTransitions[*body.Status]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown order status %s", *body.Status)})
+			return
+		}
+		if *body.Status == models.OrderStatusVoid && !middleware.HasRole(c, models.RoleOwner, models.RoleManager) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only managers may void an order"})
+			return
 		}
-		result, err := orderCollection.UpdateOne(ctx, filter, bson.D{
-			{Key: "$set", Value: updateObj},
-		}, &opt)
 
+		order, err := transitionOrder(ctx, orderId, *body.Status, c.GetString("user_id"))
 		if err != nil {
-			msg := "Order update failed"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			respondTransitionError(c, err)
 			return
 		}
-		c.JSON(http.StatusOK, result)
 
+		c.JSON(http.StatusOK, order)
 	}
 }
 
-func OrderItemOrderCreator(order models.Order) string{
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+// orderTransitionError is returned when an order cannot move from its
+// current status to the requested one.
+type orderTransitionError struct {
+	From    string
+	To      string
+	Allowed []string
+}
+
+func (e *orderTransitionError) Error() string {
+	return fmt.Sprintf("order cannot move from %s to %s", e.From, e.To)
+}
+
+func respondTransitionError(c *gin.Context, err error) {
+	var transitionErr *orderTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error(), "allowed": transitionErr.Allowed})
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "order was not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Order status update failed"})
+	}
+}
 
-	order.Created_at,_=time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.Updated_at,_=time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+// orderStatus returns the status of an order, treating orders created before
+// statuses existed as open.
+func orderStatus(order models.Order) string {
+	if order.Order_status == nil {
+		return models.OrderStatusOpen
+	}
+	return *order.Order_status
+}
+
+func canTransitionOrder(from string, to string) bool {
+	for _, allowed := range models.OrderStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// openOrder sets the initial status of a new order.
+func openOrder(order *models.Order, userId string) {
+	status := models.OrderStatusOpen
+	order.Order_status = &status
+	order.Status_history = []models.OrderStatusChange{{
+		Status:     status,
+		Changed_by: userId,
+		Changed_at: time.Now(),
+	}}
+}
 
-	order.ID=primitive.NewObjectID()
-	order.Order_id=order.ID.Hex()
-	orderCollection.InsertOne(ctx,order)
+// transitionOrder moves an order to status `to` and records when and by whom.
+// The update is conditional on the status read beforehand, so two concurrent
+// transitions cannot both succeed.
+func transitionOrder(ctx context.Context, orderId string, to string, userId string) (models.Order, error) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if err != nil {
+		return order, err
+	}
+
+	from := orderStatus(order)
+	if !canTransitionOrder(from, to) {
+		return order, &orderTransitionError{From: from, To: to, Allowed: models.OrderStatusTransitions[from]}
+	}
+
+	now := time.Now()
+	Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	change := models.OrderStatusChange{Status: to, Changed_by: userId, Changed_at: now}
+
+	wasInKitchen := orderIsInKitchen(order)
+
+	filter := bson.M{"order_id": orderId, "order_status": order.Order_status}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = orderCollection.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "order_status", Value: to},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+		{Key: "$push", Value: bson.D{{Key: "status_history", Value: change}}},
+	}, opts).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, &orderTransitionError{From: from, To: to, Allowed: models.OrderStatusTransitions[from]}
+	}
+	if err != nil {
+		return order, err
+	}
+
+	switch {
+	case to == models.OrderStatusFired:
+		queueOrderItems(ctx, order.Order_id, now)
+		publishOrderItems(ctx, models.KitchenEventItemAdded, order)
+	case wasInKitchen && (to == models.OrderStatusCancelled || to == models.OrderStatusVoid):
+		publishOrderItems(ctx, models.KitchenEventItemCancelled, order)
+	}
+	syncTableStatus(ctx, order)
+
+	return order, nil
+}
+
+func OrderItemOrderCreator(order models.Order) string {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
+	order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if order.Order_status == nil {
+		openOrder(&order, "")
+	}
+
+	order.ID = primitive.NewObjectID()
+	order.Order_id = order.ID.Hex()
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		log.Println(err)
+	} else {
+		syncTableStatus(ctx, order)
+	}
+
 	return order.Order_id
 
 }
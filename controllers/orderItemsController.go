@@ -2,9 +2,12 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"restaurant-management/database"
+	"restaurant-management/middleware"
 	"restaurant-management/models"
 	"time"
 
@@ -15,20 +18,24 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// orderItemPack adds items to the order given by Order_id, or to a new order
+// for Table_id when there is none.
 type orderItemPack struct {
 	Table_id    *string
+	Order_id    *string
 	Order_items []models.OrderItem
 }
 
 var orderItemCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItem")
+var prepTimeCollection *mongo.Collection = database.OpenCollection(database.Client, "prepTime")
 
 func GetOrderItems() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		result, err := orderItemCollection.Find(context.TODO(), bson.M{})
-		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Error occured while fetching Order Items"})
 		}
@@ -61,6 +68,7 @@ func CreateOrderItem() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var orderItemPack orderItemPack
 		var order models.Order
 
@@ -70,92 +78,756 @@ func CreateOrderItem() gin.HandlerFunc {
 
 		}
 		order.Order_Date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		orderItemsToBeInserted := []interface{}{}
 		order.Table_id = orderItemPack.Table_id
-		order_id := OrderItemOrderCreator(order)
+		openOrder(&order, c.GetString("user_id"))
 
-		for _, orderItem := range orderItemPack.Order_items {
-			orderItem.Order_id = order_id
-			validationErr := validate.Struct(orderItem)
+		for i := range orderItemPack.Order_items {
+			validationErr := validate.StructExcept(orderItemPack.Order_items[i], "Order_id")
 			if validationErr != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 				return
 			}
-			orderItem.ID = primitive.NewObjectID()
-			orderItem.Order_item_id = order.ID.Hex()
-			orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			if err := checkNewOrderItem(orderItemPack.Order_items[i]); err != nil {
+				respondOrderItemError(c, err)
+				return
+			}
+		}
 
-			var num = toFixed(*orderItem.Unit_price, 2)
-			orderItem.Unit_price = &num
+		if orderItemPack.Order_id != nil {
+			var ongoing models.Order
+			err := orderCollection.FindOne(ctx, bson.M{"order_id": orderItemPack.Order_id, "order_status": bson.M{"$in": ongoingOrderStatuses}}).Decode(&ongoing)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "no ongoing order was found"})
+				return
+			}
+			order = ongoing
 		}
-		insertOrderItems, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
-		defer cancel()
+
+		insertOrderItems, err := placeOrderItems(ctx, orderItemPack.Order_items, func() (string, error) {
+			if order.Order_id != "" {
+				return order.Order_id, nil
+			}
+			return OrderItemOrderCreator(order), nil
+		}, nil)
 		if err != nil {
-			log.Fatal(err)
+			respondOrderItemError(c, err)
+			return
+		}
+		if order.Order_id != "" && orderIsInKitchen(order) {
+			// Added to an order the kitchen is already working on.
+			queueOrderItems(ctx, order.Order_id, time.Now())
+			publishInsertedItems(ctx, order, insertOrderItems)
 		}
 		c.JSON(http.StatusOK, insertOrderItems)
 
 	}
 }
 
+// publishInsertedItems sends freshly inserted items to the kitchen.
+func publishInsertedItems(ctx context.Context, order models.Order, inserted *mongo.InsertManyResult) {
+	result, err := orderItemCollection.Find(ctx, bson.M{"_id": bson.M{"$in": inserted.InsertedIDs}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var orderItems []models.OrderItem
+	if err = result.All(ctx, &orderItems); err != nil {
+		log.Println(err)
+		return
+	}
+	publishKitchenEvents(ctx, models.KitchenEventItemAdded, order, orderItems)
+}
+
+// placeOrderItems prices validated order items, expanding bundles into their
+// components, reserves their portions and inserts them into the order given
+// by orderFor. The order is only asked for once every item has been accepted,
+// so a refused item never leaves an empty order behind. What the server keeps
+// track of on an item is reset whatever the client sent, then prepare, when
+// set, is applied to every document before it is inserted.
+func placeOrderItems(ctx context.Context, orderItems []models.OrderItem, orderFor func() (string, error), prepare func(*models.OrderItem)) (*mongo.InsertManyResult, error) {
+	components := make([][]models.OrderItem, len(orderItems))
+	for i := range orderItems {
+		var err error
+		if orderItems[i].Bundle_id != nil {
+			components[i], err = expandBundle(ctx, &orderItems[i])
+		} else {
+			err = priceOrderItem(ctx, &orderItems[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var requests []portionReservation
+	for i, orderItem := range orderItems {
+		if orderItem.Food_id != nil {
+			requests = append(requests, portionReservation{Food_id: *orderItem.Food_id, Quantity: *orderItem.Quantity})
+		}
+		for _, component := range components[i] {
+			requests = append(requests, portionReservation{Food_id: *component.Food_id, Quantity: *component.Quantity})
+		}
+	}
+	reserved, err := reservePortions(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	order_id, err := orderFor()
+	if err != nil {
+		releasePortions(ctx, reserved)
+		return nil, err
+	}
+
+	orderItemsToBeInserted := []interface{}{}
+	for i, orderItem := range orderItems {
+		orderItem.Order_id = order_id
+		orderItem.ID = primitive.NewObjectID()
+		orderItem.Order_item_id = orderItem.ID.Hex()
+		orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		resetOrderItemState(&orderItem)
+		if prepare != nil {
+			prepare(&orderItem)
+		}
+
+		orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
+
+		for _, component := range components[i] {
+			resetOrderItemState(&component)
+			component.Order_id = order_id
+			component.Parent_order_item_id = &orderItem.Order_item_id
+			component.ID = primitive.NewObjectID()
+			component.Order_item_id = component.ID.Hex()
+			component.Created_at = orderItem.Created_at
+			component.Updated_at = orderItem.Updated_at
+			if prepare != nil {
+				prepare(&component)
+			}
+
+			orderItemsToBeInserted = append(orderItemsToBeInserted, component)
+		}
+	}
+
+	result, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
+	if err != nil {
+		releasePortions(ctx, reserved)
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkNewOrderItem refuses a new order item that comes already voided,
+// comped or attached to a bundle. Voids and comps go through AdjustOrderItem,
+// which asks for a reason and a manager's approval.
+func checkNewOrderItem(orderItem models.OrderItem) error {
+	if orderItem.Voided_quantity != 0 || orderItem.Comped_quantity != 0 || orderItem.Voided {
+		return &orderItemError{Status: http.StatusBadRequest, Code: "ADJUSTMENT_NOT_ALLOWED", Message: "order items are voided or comped once ordered, with a reason"}
+	}
+	if orderItem.Parent_order_item_id != nil {
+		return &orderItemError{Status: http.StatusBadRequest, Code: "BUNDLE_COMPONENT", Message: "bundle components are ordered through their bundle"}
+	}
+	return nil
+}
+
+// resetOrderItemState clears what only the server sets on an order item: its
+// voids and comps, its bundle, its preparation and its confirmation.
+func resetOrderItemState(orderItem *models.OrderItem) {
+	orderItem.Voided_quantity = 0
+	orderItem.Comped_quantity = 0
+	orderItem.Voided = false
+	orderItem.Parent_order_item_id = nil
+	orderItem.Item_status = nil
+	orderItem.Queued_at = nil
+	orderItem.Cooking_at = nil
+	orderItem.Ready_at = nil
+	orderItem.Delivered_at = nil
+	orderItem.Prep_seconds = nil
+	orderItem.Awaiting_confirmation = false
+	orderItem.Guest_session_id = nil
+	orderItem.Confirmed_by = nil
+	orderItem.Confirmed_at = nil
+}
+
+// checkOrderItemsChangeable refuses to change the items of an order that is
+// no longer ongoing, or that payments were taken on: its bill is settled.
+func checkOrderItemsChangeable(ctx context.Context, orderId string) error {
+	count, err := orderCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "order_status": bson.M{"$in": ongoingOrderStatuses}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return &orderItemError{Status: http.StatusConflict, Code: "ORDER_NOT_ONGOING", Message: "the order is no longer ongoing, its items cannot change"}
+	}
+	count, err = invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "payment_status": bson.M{"$nin": bson.A{nil, models.InvoiceStatusPending}}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &orderItemError{Status: http.StatusConflict, Code: "ORDER_ALREADY_PAID", Message: "payments were taken on the order, refund them instead"}
+	}
+	return nil
+}
+
+// UpdateOrderItem changes the quantity, seat or choices of an item of an
+// ongoing order. Unpaid invoices of the order are recomputed.
 func UpdateOrderItem() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var orderItem models.OrderItem
-		orderItemId := c.Param("Order_item_id")
+		var foundItem models.OrderItem
+		if err := c.BindJSON(&orderItem); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		orderItemId := c.Param("orderItem_id")
 		filter := bson.M{"order_item_id": orderItemId}
 
-		var updateObj primitive.D
-
-		if orderItem.Unit_price != nil {
-			updateObj = append(updateObj, bson.E{Key: "unit_price", Value: &orderItem.Unit_price})
+		err := orderItemCollection.FindOne(ctx, filter).Decode(&foundItem)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+			return
+		}
 
+		if foundItem.Parent_order_item_id != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "bundle components are changed through their bundle", "code": "BUNDLE_COMPONENT"})
+			return
 		}
+		if foundItem.Voided {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order item was voided", "code": "ITEM_VOIDED"})
+			return
+		}
+		if err := checkOrderItemsChangeable(ctx, foundItem.Order_id); err != nil {
+			respondOrderItemError(c, err)
+			return
+		}
+		isBundle := foundItem.Bundle_id != nil
+
+		var updateObj primitive.D
+
 		if orderItem.Quantity != nil {
-			updateObj = append(updateObj, bson.E{Key: "quantity", Value: &orderItem.Quantity})
+			if *orderItem.Quantity < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be at least 1"})
+				return
+			}
+			if *orderItem.Quantity < foundItem.Voided_quantity+foundItem.Comped_quantity {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quantity is less than what was voided or comped", "code": "QUANTITY_BELOW_ADJUSTED"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "quantity", Value: orderItem.Quantity})
 
 		}
-		if orderItem.Food_id != nil {
-			updateObj = append(updateObj, bson.E{Key: "food_id", Value: &orderItem.Food_id})
+		if orderItem.Seat != nil {
+			if *orderItem.Seat < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "seat must be at least 1"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "seat", Value: orderItem.Seat})
+		}
+		if isBundle && (orderItem.Food_id != nil || orderItem.Variant_id != nil || orderItem.Modifiers != nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a bundle can only change its quantity", "code": "BUNDLE_COMPONENT"})
+			return
+		}
+		if orderItem.Food_id != nil || orderItem.Variant_id != nil || orderItem.Modifiers != nil {
+			// Changing the dish, its variant or its modifiers reprices the item
+			// from the menu. Choices not sent are kept unless the dish changed.
+			if orderItem.Food_id == nil || (foundItem.Food_id != nil && *orderItem.Food_id == *foundItem.Food_id) {
+				orderItem.Food_id = foundItem.Food_id
+				if orderItem.Variant_id == nil {
+					orderItem.Variant_id = foundItem.Variant_id
+				}
+				if orderItem.Modifiers == nil {
+					orderItem.Modifiers = foundItem.Modifiers
+				}
+			}
+			if validationErr := validate.Var(orderItem.Modifiers, "dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			if err := priceOrderItem(ctx, &orderItem); err != nil {
+				respondOrderItemError(c, err)
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "food_id", Value: orderItem.Food_id})
+			updateObj = append(updateObj, bson.E{Key: "variant_id", Value: orderItem.Variant_id})
+			updateObj = append(updateObj, bson.E{Key: "variant_name", Value: orderItem.Variant_name})
+			updateObj = append(updateObj, bson.E{Key: "modifiers", Value: orderItem.Modifiers})
+			updateObj = append(updateObj, bson.E{Key: "unit_price", Value: orderItem.Unit_price})
 
 		}
 		orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: orderItem.Updated_at})
 
-		upsert := true
-
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+		toReserve, toRelease, err := portionChanges(ctx, foundItem, orderItem)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while updating order Item"})
+			return
 		}
-		result, err := orderItemCollection.UpdateOne(ctx, filter,
+		reserved, err := reservePortions(ctx, toReserve)
+		if err != nil {
+			respondOrderItemError(c, err)
+			return
+		}
+
+		var updatedItem models.OrderItem
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = orderItemCollection.FindOneAndUpdate(ctx, filter,
 			bson.D{{Key: "$set", Value: updateObj}},
-			&opt,
-		)
+			opts,
+		).Decode(&updatedItem)
 
+		if err == mongo.ErrNoDocuments {
+			releasePortions(ctx, reserved)
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+			return
+		}
 		if err != nil {
+			releasePortions(ctx, reserved)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while updating order Item"})
 			return
 		}
+		releasePortions(ctx, toRelease)
+
+		if isBundle && orderItem.Quantity != nil {
+			updateBundleComponents(ctx, updatedItem)
+		} else {
+			publishIfFired(ctx, models.KitchenEventItemUpdated, updatedItem.Order_id, []models.OrderItem{updatedItem})
+		}
+		refreshOrderInvoices(ctx, updatedItem.Order_id)
+
+		c.JSON(http.StatusOK, updatedItem)
+
+	}
+}
+
+// UpdateOrderItemStatus bumps the preparation status of an order item. The
+// kitchen moves items through cooking to ready, floor staff mark them delivered.
+func UpdateOrderItemStatus() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		c.JSON(http.StatusOK, result)
 
+		orderItemId := c.Param("orderItem_id")
+
+		var body struct {
+			Status *string `json:"status" validate:"required,eq=COOKING|eq=READY|eq=DELIVERED"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if *body.Status == models.ItemStatusDelivered {
+			if !middleware.HasRole(c, models.RoleOwner, models.RoleManager, models.RoleWaiter) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only floor staff may mark an item as delivered"})
+				return
+			}
+		} else if !middleware.HasRole(c, models.RoleOwner, models.RoleManager, models.RoleKitchen) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the kitchen may change the preparation status"})
+			return
+		}
+
+		var orderItem models.OrderItem
+		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+			return
+		}
+		if orderItem.Bundle_id != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "bundles are prepared through their components", "code": "BUNDLE_COMPONENT"})
+			return
+		}
+		if orderItem.Item_status == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "order item has not been sent to the kitchen yet"})
+			return
+		}
+
+		from := *orderItem.Item_status
+		to := *body.Status
+		if !canTransitionItem(from, to) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("order item cannot move from %s to %s", from, to), "allowed": models.ItemStatusTransitions[from]})
+			return
+		}
+
+		now := time.Now()
+		Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+
+		updateObj := primitive.D{
+			{Key: "item_status", Value: to},
+			{Key: "updated_at", Value: Updated_at},
+		}
+		switch to {
+		case models.ItemStatusCooking:
+			updateObj = append(updateObj, bson.E{Key: "cooking_at", Value: now})
+		case models.ItemStatusReady:
+			updateObj = append(updateObj, bson.E{Key: "ready_at", Value: now})
+			if prepSeconds, ok := prepDuration(orderItem, now); ok {
+				updateObj = append(updateObj, bson.E{Key: "prep_seconds", Value: prepSeconds})
+			}
+		case models.ItemStatusDelivered:
+			updateObj = append(updateObj, bson.E{Key: "delivered_at", Value: now})
+		}
+
+		filter := bson.M{"order_item_id": orderItemId, "item_status": from}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = orderItemCollection.FindOneAndUpdate(ctx, filter, bson.D{
+			{Key: "$set", Value: updateObj},
+		}, opts).Decode(&orderItem)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusConflict, gin.H{"error": "order item status was changed by someone else, reload and try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while updating order Item"})
+			return
+		}
+
+		if to == models.ItemStatusReady {
+			recordPrepTime(ctx, orderItem)
+		}
+		publishIfFired(ctx, models.KitchenEventItemUpdated, orderItem.Order_id, []models.OrderItem{orderItem})
+		rollupOrderStatus(ctx, orderItem.Order_id, c.GetString("user_id"))
+
+		c.JSON(http.StatusOK, orderItem)
+	}
+}
+
+// portionChanges works out the portions an order item update takes from, and
+// gives back to, the foods it is made of.
+func portionChanges(ctx context.Context, foundItem models.OrderItem, orderItem models.OrderItem) (toReserve []portionReservation, toRelease []portionReservation, err error) {
+	var oldQuantity int
+	if foundItem.Quantity != nil {
+		oldQuantity = *foundItem.Quantity
+	}
+	newQuantity := oldQuantity
+	if orderItem.Quantity != nil {
+		newQuantity = *orderItem.Quantity
+	}
+
+	var oldFoods []string
+	var newFoods []string
+	if foundItem.Bundle_id != nil {
+		result, err := orderItemCollection.Find(ctx, bson.M{"parent_order_item_id": foundItem.Order_item_id})
+		if err != nil {
+			return nil, nil, err
+		}
+		var components []models.OrderItem
+		if err = result.All(ctx, &components); err != nil {
+			return nil, nil, err
+		}
+		for _, component := range components {
+			oldFoods = append(oldFoods, *component.Food_id)
+		}
+		newFoods = oldFoods
+	} else if foundItem.Food_id != nil {
+		oldFoods = []string{*foundItem.Food_id}
+		newFoods = oldFoods
+		if orderItem.Food_id != nil {
+			newFoods = []string{*orderItem.Food_id}
+		}
+	}
+
+	for i := range oldFoods {
+		if oldFoods[i] != newFoods[i] {
+			toReserve = append(toReserve, portionReservation{Food_id: newFoods[i], Quantity: newQuantity})
+			toRelease = append(toRelease, portionReservation{Food_id: oldFoods[i], Quantity: oldQuantity})
+			continue
+		}
+		if newQuantity > oldQuantity {
+			toReserve = append(toReserve, portionReservation{Food_id: oldFoods[i], Quantity: newQuantity - oldQuantity})
+		} else if newQuantity < oldQuantity {
+			toRelease = append(toRelease, portionReservation{Food_id: oldFoods[i], Quantity: oldQuantity - newQuantity})
+		}
 	}
+	return toReserve, toRelease, nil
+}
+
+// updateBundleComponents carries the quantity of a bundle line over to the
+// components the kitchen prepares for it.
+func updateBundleComponents(ctx context.Context, bundleItem models.OrderItem) {
+	filter := bson.M{"parent_order_item_id": bundleItem.Order_item_id}
+	_, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "quantity", Value: bundleItem.Quantity},
+			{Key: "updated_at", Value: bundleItem.Updated_at},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	result, err := orderItemCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var components []models.OrderItem
+	if err = result.All(ctx, &components); err != nil {
+		log.Println(err)
+		return
+	}
+	publishIfFired(ctx, models.KitchenEventItemUpdated, bundleItem.Order_id, components)
+}
+
+func canTransitionItem(from string, to string) bool {
+	for _, allowed := range models.ItemStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// prepDuration is the time from the start of cooking, or from queueing when
+// the cooking step was skipped, until now.
+func prepDuration(orderItem models.OrderItem, now time.Time) (int64, bool) {
+	start := orderItem.Cooking_at
+	if start == nil {
+		start = orderItem.Queued_at
+	}
+	if start == nil {
+		return 0, false
+	}
+	return int64(now.Sub(*start).Seconds()), true
+}
+
+// recordPrepTime stores the preparation time of a ready item for analytics.
+func recordPrepTime(ctx context.Context, orderItem models.OrderItem) {
+	if orderItem.Ready_at == nil || orderItem.Queued_at == nil {
+		return
+	}
+
+	var food models.Food
+	if orderItem.Food_id != nil {
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.Food_id}).Decode(&food); err != nil {
+			log.Println(err)
+		}
+	}
+
+	var prepTime models.PrepTime
+	prepTime.ID = primitive.NewObjectID()
+	prepTime.Prep_time_id = prepTime.ID.Hex()
+	prepTime.Food_id = orderItem.Food_id
+	prepTime.Order_item_id = orderItem.Order_item_id
+	prepTime.Station = food.Station
+	prepTime.Ready_at = *orderItem.Ready_at
+	prepTime.Total_seconds = int64(orderItem.Ready_at.Sub(*orderItem.Queued_at).Seconds())
+	prepTime.Prep_seconds = prepTime.Total_seconds
+	if orderItem.Cooking_at != nil {
+		prepTime.Wait_seconds = int64(orderItem.Cooking_at.Sub(*orderItem.Queued_at).Seconds())
+		prepTime.Prep_seconds = int64(orderItem.Ready_at.Sub(*orderItem.Cooking_at).Seconds())
+	}
+	prepTime.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if _, err := prepTimeCollection.InsertOne(ctx, prepTime); err != nil {
+		log.Println(err)
+	}
+}
+
+// rollupOrderStatus moves a fired order to READY once all of its items are
+// ready, and to SERVED once all of them have been delivered.
+func rollupOrderStatus(ctx context.Context, orderId string, userId string) {
+	result, err := orderItemCollection.Find(ctx, bson.M{"order_id": orderId, "bundle_id": nil, "awaiting_confirmation": bson.M{"$ne": true}, "voided": bson.M{"$ne": true}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var orderItems []models.OrderItem
+	if err = result.All(ctx, &orderItems); err != nil {
+		log.Println(err)
+		return
+	}
+	if len(orderItems) == 0 {
+		return
+	}
+
+	allReady, allDelivered := true, true
+	for _, orderItem := range orderItems {
+		status := ""
+		if orderItem.Item_status != nil {
+			status = *orderItem.Item_status
+		}
+		if status != models.ItemStatusReady && status != models.ItemStatusDelivered {
+			allReady = false
+		}
+		if status != models.ItemStatusDelivered {
+			allDelivered = false
+		}
+	}
+
+	var order models.Order
+	if err = orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order); err != nil {
+		log.Println(err)
+		return
+	}
+
+	to := ""
+	switch {
+	case allDelivered:
+		to = models.OrderStatusServed
+	case allReady:
+		to = models.OrderStatusReady
+	}
+	if to == "" || to == orderStatus(order) || !canTransitionOrder(orderStatus(order), to) {
+		return
+	}
+
+	if _, err = transitionOrder(ctx, orderId, to, userId); err != nil {
+		log.Println(err)
+	}
+}
+
+// orderItemError rejects an order item with a status and a machine readable code.
+type orderItemError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *orderItemError) Error() string {
+	return e.Message
+}
+
+func respondOrderItemError(c *gin.Context, err error) {
+	var itemErr *orderItemError
+	if errors.As(err, &itemErr) {
+		c.JSON(itemErr.Status, gin.H{"error": itemErr.Message, "code": itemErr.Code})
+		return
+	}
+	log.Println(err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while saving the order item"})
+}
+
+// priceOrderItem sets the unit price of an order item from its food and
+// chosen variant. Prices sent by the client are ignored, and foods whose menu
+// is not being served are refused.
+func priceOrderItem(ctx context.Context, orderItem *models.OrderItem) error {
+	var food models.Food
+	err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.Food_id}).Decode(&food)
+	if err == mongo.ErrNoDocuments {
+		return &orderItemError{Status: http.StatusNotFound, Code: "FOOD_NOT_FOUND", Message: "Food was not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if err = checkMenuAvailable(ctx, food.Menu_id); err != nil {
+		return err
+	}
+
+	price := *food.Price
+	orderItem.Variant_name = nil
+
+	if orderItem.Variant_id != nil && *orderItem.Variant_id != "" {
+		var variant *models.FoodVariant
+		for i := range food.Variants {
+			if food.Variants[i].Variant_id == *orderItem.Variant_id {
+				variant = &food.Variants[i]
+				break
+			}
+		}
+		if variant == nil {
+			return &orderItemError{Status: http.StatusBadRequest, Code: "VARIANT_NOT_FOUND", Message: fmt.Sprintf("%s has no variant %s", *food.Name, *orderItem.Variant_id)}
+		}
+		price += *variant.Price_delta
+		orderItem.Variant_name = variant.Name
+	} else {
+		orderItem.Variant_id = nil
+	}
+
+	modifiersPrice, err := resolveModifiers(food, orderItem.Modifiers)
+	if err != nil {
+		return err
+	}
+	price += modifiersPrice
+
+	var num = toFixed(price, 2)
+	orderItem.Unit_price = &num
+	return nil
+}
+
+// resolveModifiers checks the options chosen for an order item against the
+// option groups of its food, fills in their names and prices and returns the
+// price they add to one unit.
+func resolveModifiers(food models.Food, modifiers []models.OrderItemModifier) (float64, error) {
+	var total float64
+	selected := map[string]int{}
+	seen := map[string]bool{}
+
+	for i := range modifiers {
+		modifier := &modifiers[i]
+
+		var group *models.OptionGroup
+		for j := range food.Option_groups {
+			if food.Option_groups[j].Group_id == modifier.Group_id {
+				group = &food.Option_groups[j]
+				break
+			}
+		}
+		if group == nil {
+			return 0, &orderItemError{Status: http.StatusBadRequest, Code: "OPTION_GROUP_NOT_FOUND", Message: fmt.Sprintf("%s has no option group %s", *food.Name, modifier.Group_id)}
+		}
+
+		var option *models.FoodOption
+		for j := range group.Options {
+			if group.Options[j].Option_id == modifier.Option_id {
+				option = &group.Options[j]
+				break
+			}
+		}
+		if option == nil {
+			return 0, &orderItemError{Status: http.StatusBadRequest, Code: "OPTION_NOT_FOUND", Message: fmt.Sprintf("%s has no option %s", *group.Name, modifier.Option_id)}
+		}
+
+		key := modifier.Group_id + "/" + modifier.Option_id
+		if seen[key] {
+			return 0, &orderItemError{Status: http.StatusBadRequest, Code: "OPTION_DUPLICATED", Message: fmt.Sprintf("%s was chosen more than once", *option.Name)}
+		}
+		seen[key] = true
+		selected[group.Group_id]++
+
+		var price float64
+		if option.Price_delta != nil {
+			price = *option.Price_delta
+		}
+		modifier.Group_name = group.Name
+		modifier.Name = option.Name
+		modifier.Price = &price
+		total += price
+	}
+
+	for _, group := range food.Option_groups {
+		count := selected[group.Group_id]
+		if count < group.Min_select {
+			return 0, &orderItemError{Status: http.StatusBadRequest, Code: "OPTION_SELECTION_INVALID", Message: fmt.Sprintf("%s: pick at least %d", *group.Name, group.Min_select)}
+		}
+		if count > group.Max_select {
+			return 0, &orderItemError{Status: http.StatusBadRequest, Code: "OPTION_SELECTION_INVALID", Message: fmt.Sprintf("%s: pick at most %d", *group.Name, group.Max_select)}
+		}
+	}
+
+	return total, nil
 }
 
 func GetOrderItemByOrder() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		orderItemId := c.Param("order_item_id")
 
 		var orderItem models.OrderItem
 
 		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
-		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error Occured while Getting Order Item By Order"})
 			return
@@ -166,37 +838,52 @@ func GetOrderItemByOrder() gin.HandlerFunc {
 
 func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
 
-	matchStage := bson.D{{Key: "$match", Value: bson.D{{Key: "order_id", Value: id}}}}
-	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "food"}, {Key: "localfield", Value: "food_id"}, {Key: "foreignfield", Value: "food_id"}}}}
+	// Bundle components are billed through their bundle line, guest items
+	// once staff confirmed them.
+	matchStage := bson.D{{Key: "$match", Value: bson.D{{Key: "order_id", Value: id}, {Key: "parent_order_item_id", Value: nil}, {Key: "awaiting_confirmation", Value: bson.D{{Key: "$ne", Value: true}}}}}}
+	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "food"}, {Key: "localField", Value: "food_id"}, {Key: "foreignField", Value: "food_id"}, {Key: "as", Value: "food"}}}}
 	unwindStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$food"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
-	lookupOrderStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "order"}, {Key: "localfield", Value: "order_id"}, {Key: "foreignfield", Value: "order_id"}}}}
+	lookupBundleStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "bundle"}, {Key: "localField", Value: "bundle_id"}, {Key: "foreignField", Value: "bundle_id"}, {Key: "as", Value: "bundle"}}}}
+	unwindBundleStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$bundle"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
+	lookupOrderStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "order"}, {Key: "localField", Value: "order_id"}, {Key: "foreignField", Value: "order_id"}, {Key: "as", Value: "order"}}}}
 	unwindOrderStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$order"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 
-	lookupTableStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "table"}, {Key: "localfield", Value: "table_id"}, {Key: "foreignfield", Value: "table_id"}}}}
+	lookupTableStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "table"}, {Key: "localField", Value: "order.table_id"}, {Key: "foreignField", Value: "table_id"}, {Key: "as", Value: "table"}}}}
 	unwindTableStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$table"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 
+	// Voided and comped quantities are not charged.
+	chargedQuantity := bson.D{{Key: "$subtract", Value: bson.A{"$quantity", bson.D{{Key: "$add", Value: bson.A{
+		bson.D{{Key: "$ifNull", Value: bson.A{"$voided_quantity", 0}}},
+		bson.D{{Key: "$ifNull", Value: bson.A{"$comped_quantity", 0}}},
+	}}}}}}
 	projectStage := bson.D{{
 		Key: "$project", Value: bson.D{
-			{Key: "id", Value: 0},
-			{Key: "amount", Value: "$food.price"},
-			{Key: "total_count", Value: 1},
-			{Key: "food_name", Value: "$food.food_name"},
-			{Key: "food_image", Value: "$food.food_image"},
+			{Key: "_id", Value: 0},
+			{Key: "order_item_id", Value: 1},
+			{Key: "amount", Value: bson.D{{Key: "$multiply", Value: bson.A{"$unit_price", chargedQuantity}}}},
+			{Key: "food_name", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$food.name", "$bundle.name"}}}},
+			{Key: "food_image", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$food.food_image", "$bundle.bundle_image"}}}},
+			{Key: "bundle_id", Value: 1},
+			{Key: "variant_name", Value: 1},
+			{Key: "modifiers", Value: 1},
+			{Key: "voided_quantity", Value: 1},
+			{Key: "comped_quantity", Value: 1},
 			{Key: "table_number", Value: "$table.table_number"},
 			{Key: "table_id", Value: "$table.table_id"},
 			{Key: "order_id", Value: "$order.order_id"},
-			{Key: "price", Value: "$food.price"},
+			{Key: "price", Value: "$unit_price"},
 			{Key: "quantity", Value: 1},
 		},
 	}}
-	groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "id", Value: bson.D{{Key: "order_id", Value: "$order_id"}, {Key: "table_id", Value: "$table_id"}, {Key: "table_number", Value: "$table_number"}}}, {Key: "payment_due", Value: bson.D{{Key: "$sum", Value: "$amount"}}}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "order_items", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
+	groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "order_id", Value: "$order_id"}, {Key: "table_id", Value: "$table_id"}, {Key: "table_number", Value: "$table_number"}}}, {Key: "payment_due", Value: bson.D{{Key: "$sum", Value: "$amount"}}}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: "$quantity"}}}, {Key: "order_items", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
 
 	projectStage2 := bson.D{
 		{Key: "$project", Value: bson.D{
 
-			{Key: "id", Value: 0},
-			{Key: "payment_due", Value: 1},
+			{Key: "_id", Value: 0},
+			{Key: "payment_due", Value: bson.D{{Key: "$round", Value: bson.A{"$payment_due", 2}}}},
 			{Key: "total_count", Value: 1},
 			{Key: "table_number", Value: "$_id.table_number"},
 			{Key: "order_items", Value: 1},
@@ -206,6 +893,8 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 		matchStage,
 		lookupStage,
 		unwindStage,
+		lookupBundleStage,
+		unwindBundleStage,
 		lookupOrderStage,
 		unwindOrderStage,
 		lookupTableStage,
@@ -215,15 +904,13 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 		projectStage2})
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if err = result.All(ctx, &OrderItems); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	defer cancel()
-
-	return OrderItems, err
+	return OrderItems, nil
 
 }
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"restaurant-management/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateOrderItemRejectsAdjustments(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     gin.H
+		wantCode string
+	}{
+		{name: "comped", item: gin.H{"comped_quantity": 2}, wantCode: "ADJUSTMENT_NOT_ALLOWED"},
+		{name: "voided in part", item: gin.H{"voided_quantity": 1}, wantCode: "ADJUSTMENT_NOT_ALLOWED"},
+		{name: "voided", item: gin.H{"voided": true}, wantCode: "ADJUSTMENT_NOT_ALLOWED"},
+		{name: "negative comp", item: gin.H{"comped_quantity": -3}},
+		{name: "bundle component", item: gin.H{"parent_order_item_id": "bundle-line"}, wantCode: "BUNDLE_COMPONENT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := gin.H{"food_id": "food-1", "quantity": 2}
+			for key, value := range tt.item {
+				item[key] = value
+			}
+
+			w := performJSON(CreateOrderItem(), gin.H{"order_items": []gin.H{item}})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			var body struct{ Code string }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCode != "" && body.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestResetOrderItemState(t *testing.T) {
+	status := models.ItemStatusReady
+	parent := "bundle-line"
+	session := "session-1"
+	user := "user-1"
+	now := time.Now()
+	prep := int64(300)
+	orderItem := models.OrderItem{
+		Voided_quantity:       1,
+		Comped_quantity:       2,
+		Voided:                true,
+		Parent_order_item_id:  &parent,
+		Item_status:           &status,
+		Queued_at:             &now,
+		Cooking_at:            &now,
+		Ready_at:              &now,
+		Delivered_at:          &now,
+		Prep_seconds:          &prep,
+		Awaiting_confirmation: true,
+		Guest_session_id:      &session,
+		Confirmed_by:          &user,
+		Confirmed_at:          &now,
+	}
+
+	resetOrderItemState(&orderItem)
+	if orderItem.Voided_quantity != 0 || orderItem.Comped_quantity != 0 || orderItem.Voided || orderItem.Parent_order_item_id != nil {
+		t.Errorf("adjustments kept: %+v", orderItem)
+	}
+	if orderItem.Item_status != nil || orderItem.Queued_at != nil || orderItem.Cooking_at != nil || orderItem.Ready_at != nil || orderItem.Delivered_at != nil || orderItem.Prep_seconds != nil {
+		t.Errorf("preparation kept: %+v", orderItem)
+	}
+	if orderItem.Awaiting_confirmation || orderItem.Guest_session_id != nil || orderItem.Confirmed_by != nil || orderItem.Confirmed_at != nil {
+		t.Errorf("confirmation kept: %+v", orderItem)
+	}
+}
+
+func TestUpdateOrderItemOrderStatus(t *testing.T) {
+	requireDatabase(t)
+
+	tests := []struct {
+		name       string
+		status     string
+		paid       bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "served order", status: models.OrderStatusServed, wantStatus: http.StatusOK},
+		{name: "order paid in part", status: models.OrderStatusServed, paid: true, wantStatus: http.StatusConflict, wantCode: "ORDER_ALREADY_PAID"},
+		{name: "billed order", status: models.OrderStatusBilled, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_ONGOING"},
+		{name: "closed order", status: models.OrderStatusClosed, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_ONGOING"},
+		{name: "cancelled order", status: models.OrderStatusCancelled, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_ONGOING"},
+		{name: "voided order", status: models.OrderStatusVoid, wantStatus: http.StatusConflict, wantCode: "ORDER_NOT_ONGOING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := insertTestOrder(t, tt.status)
+			item := insertTestItems(t, order.Order_id, 10)[0]
+			orderItemCollection.UpdateOne(context.Background(), bson.M{"order_item_id": item.Order_item_id}, bson.M{"$set": bson.M{"quantity": 2}})
+
+			status := models.InvoiceStatusPending
+			if tt.paid {
+				status = models.InvoiceStatusPartiallyPaid
+			}
+			invoice := models.Invoice{ID: primitive.NewObjectID(), Order_id: order.Order_id, Payment_status: &status, Total: 1}
+			invoice.Invoice_id = invoice.ID.Hex()
+			if _, err := invoiceCollection.InsertOne(context.Background(), invoice); err != nil {
+				t.Fatal(err)
+			}
+
+			path := "/orderItems/" + item.Order_item_id
+			w := performAs(models.RoleWaiter, "/orderItems/:orderItem_id", path, UpdateOrderItem(), gin.H{"quantity": 1})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				var body struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+				}
+			}
+
+			var found models.Invoice
+			if err := invoiceCollection.FindOne(context.Background(), bson.M{"invoice_id": invoice.Invoice_id}).Decode(&found); err != nil {
+				t.Fatal(err)
+			}
+			wantTotal := invoice.Total
+			if tt.wantStatus == http.StatusOK {
+				bill, _, err := billOrder(context.Background(), order.Order_id, 0, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantTotal = bill.Total
+			}
+			if found.Total != wantTotal {
+				t.Errorf("invoice total %d, want %d", found.Total, wantTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errOrderChanged aborts a transaction when an order moved on since it was read.
+var errOrderChanged = &tableError{Status: http.StatusConflict, Code: "ORDER_CHANGED", Message: "the order was changed by someone else, reload and try again"}
+
+// TransferOrder moves an order, with its party, to another free table.
+func TransferOrder() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Table_id *string `json:"table_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		order, err := movableOrder(ctx, c.Param("order_id"))
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		order, err = transferOrder(ctx, order, *body.Table_id)
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// MergeOrders moves every item of the source order into this one, joining
+// the two parties at this order's table. The source order is cancelled and
+// records where its items went; its unpaid invoices are dropped and those of
+// this order recomputed. Orders payments were taken on are not merged.
+func MergeOrders() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Source_order_id *string `json:"source_order_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		orderId := c.Param("order_id")
+		if *body.Source_order_id == orderId {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an order cannot be merged into itself"})
+			return
+		}
+		target, err := movableOrder(ctx, orderId)
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		source, err := movableOrder(ctx, *body.Source_order_id)
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		var sourceTable models.Table
+		sameTable := source.Table_id == nil || (target.Table_id != nil && *source.Table_id == *target.Table_id)
+		if !sameTable {
+			err = tableCollection.FindOne(ctx, bson.M{"table_id": source.Table_id}).Decode(&sourceTable)
+			if err == mongo.ErrNoDocuments {
+				err = &tableError{Status: http.StatusNotFound, Code: "TABLE_NOT_FOUND", Message: "the table of the source order was not found"}
+			}
+			if err != nil {
+				respondTableError(c, err)
+				return
+			}
+		}
+
+		moved, err := orderItemsOf(ctx, bson.M{"order_id": source.Order_id})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		now := time.Now()
+		Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		userId := c.GetString("user_id")
+
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := orderCollection.UpdateOne(sessCtx, bson.M{"order_id": source.Order_id, "order_status": source.Order_status}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "order_status", Value: models.OrderStatusCancelled},
+					{Key: "merged_into", Value: target.Order_id},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+				{Key: "$push", Value: bson.D{{Key: "status_history", Value: models.OrderStatusChange{Status: models.OrderStatusCancelled, Changed_by: userId, Changed_at: now}}}},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errOrderChanged
+			}
+
+			result, err = orderCollection.UpdateOne(sessCtx, bson.M{"order_id": target.Order_id, "order_status": target.Order_status}, bson.D{
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: Updated_at}}},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errOrderChanged
+			}
+
+			if err = checkOrderUnpaid(sessCtx, source.Order_id); err != nil {
+				return err
+			}
+			if err = checkOrderUnpaid(sessCtx, target.Order_id); err != nil {
+				return err
+			}
+
+			_, err = orderItemCollection.UpdateMany(sessCtx, bson.M{"order_id": source.Order_id}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "order_id", Value: target.Order_id},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = invoiceCollection.DeleteMany(sessCtx, bson.M{"order_id": source.Order_id, "payment_status": models.InvoiceStatusPending})
+			if err != nil {
+				return err
+			}
+			if err = recomputeOrderInvoices(sessCtx, target.Order_id); err != nil {
+				return err
+			}
+
+			if sameTable {
+				return nil
+			}
+			guests := 0
+			if sourceTable.Number_of_guests != nil {
+				guests = *sourceTable.Number_of_guests
+			}
+			_, err = tableCollection.UpdateOne(sessCtx, bson.M{"table_id": target.Table_id}, bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "number_of_guests", Value: guests}}},
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: Updated_at}}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = tableCollection.UpdateOne(sessCtx, bson.M{"table_id": sourceTable.Table_id}, bson.D{
+				{Key: "$set", Value: vacatedTable(Updated_at)},
+			})
+			return err
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		switch {
+		case orderIsInKitchen(source):
+			publishKitchenEvents(ctx, models.KitchenEventItemUpdated, target, moved)
+		case orderIsInKitchen(target):
+			queueOrderItems(ctx, target.Order_id, now)
+			if moved, err = orderItemsOf(ctx, bson.M{"order_id": target.Order_id, "order_item_id": bson.M{"$in": orderItemIds(moved)}}); err == nil {
+				publishKitchenEvents(ctx, models.KitchenEventItemAdded, target, moved)
+			}
+		}
+
+		var merged models.Order
+		if err = orderCollection.FindOne(ctx, bson.M{"order_id": target.Order_id}).Decode(&merged); err != nil {
+			respondTableError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, merged)
+	}
+}
+
+// SplitOrder moves the listed order items, with the components of the
+// bundles among them, onto a new order, at the same table or at another
+// free one. The unpaid invoices of the order are recomputed without them;
+// orders payments were taken on are not split.
+func SplitOrder() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_item_ids []string `json:"order_item_ids" validate:"required,min=1"`
+			Table_id       *string  `json:"table_id"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		source, err := movableOrder(ctx, c.Param("order_id"))
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		lines, err := orderItemsOf(ctx, bson.M{"order_id": source.Order_id, "parent_order_item_id": nil})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		selected := map[string]bool{}
+		for _, id := range body.Order_item_ids {
+			selected[id] = true
+		}
+		found := 0
+		for _, line := range lines {
+			if selected[line.Order_item_id] {
+				found++
+			}
+		}
+		if found != len(selected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only items of this order can be split off, bundle components go with their bundle", "code": "ITEM_NOT_IN_ORDER"})
+			return
+		}
+		if found == len(lines) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "splitting off every item leaves an empty order, transfer the order instead", "code": "SPLIT_EMPTIES_ORDER"})
+			return
+		}
+
+		moved, err := orderItemsOf(ctx, bson.M{
+			"order_id": source.Order_id,
+			"$or": bson.A{
+				bson.M{"order_item_id": bson.M{"$in": body.Order_item_ids}},
+				bson.M{"parent_order_item_id": bson.M{"$in": body.Order_item_ids}},
+			},
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		tableId := source.Table_id
+		otherTable := body.Table_id != nil && (tableId == nil || *body.Table_id != *tableId)
+		if otherTable {
+			tableId = body.Table_id
+		}
+
+		now := time.Now()
+		Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		userId := c.GetString("user_id")
+
+		var order models.Order
+		order.ID = primitive.NewObjectID()
+		order.Order_id = order.ID.Hex()
+		order.Table_id = tableId
+		order.Split_from = &source.Order_id
+		order.Order_Date = Updated_at
+		order.Created_at = Updated_at
+		order.Updated_at = Updated_at
+		status := orderStatus(source)
+		order.Order_status = &status
+		order.Status_history = []models.OrderStatusChange{{Status: status, Changed_by: userId, Changed_at: now}}
+
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := orderCollection.UpdateOne(sessCtx, bson.M{"order_id": source.Order_id, "order_status": source.Order_status}, bson.D{
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: Updated_at}}},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errOrderChanged
+			}
+			if err = checkOrderUnpaid(sessCtx, source.Order_id); err != nil {
+				return err
+			}
+
+			if _, err = orderCollection.InsertOne(sessCtx, order); err != nil {
+				return err
+			}
+
+			moveResult, err := orderItemCollection.UpdateMany(sessCtx, bson.M{
+				"order_id":      source.Order_id,
+				"order_item_id": bson.M{"$in": orderItemIds(moved)},
+			}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "order_id", Value: order.Order_id},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+			})
+			if err != nil {
+				return err
+			}
+			if moveResult.MatchedCount != int64(len(moved)) {
+				return errOrderChanged
+			}
+			if err = recomputeOrderInvoices(sessCtx, source.Order_id); err != nil {
+				return err
+			}
+
+			if otherTable {
+				return takeTable(sessCtx, *tableId, nil, Updated_at)
+			}
+			return nil
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		if otherTable {
+			syncTableStatus(ctx, order)
+		}
+		if orderIsInKitchen(order) {
+			publishKitchenEvents(ctx, models.KitchenEventItemUpdated, order, moved)
+		}
+
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// transferOrder moves an order to another table, which must be free. The
+// party's seating time and guest count move with it and the table it left
+// needs cleaning.
+func transferOrder(ctx context.Context, order models.Order, tableId string) (models.Order, error) {
+	if order.Table_id != nil && *order.Table_id == tableId {
+		return order, &tableError{Status: http.StatusBadRequest, Code: "SAME_TABLE", Message: "the order is already at that table"}
+	}
+
+	var destination models.Table
+	if err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&destination); err != nil {
+		return order, &tableError{Status: http.StatusNotFound, Code: "TABLE_NOT_FOUND", Message: "table was not found"}
+	}
+	if _, err := ongoingTableOrder(ctx, tableId); err == nil {
+		return order, &tableError{Status: http.StatusConflict, Code: "TABLE_OCCUPIED", Message: fmt.Sprintf("table %d already has an order, merge the orders instead", *destination.Table_number)}
+	}
+
+	var origin *models.Table
+	if order.Table_id != nil {
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table); err == nil {
+			origin = &table
+		}
+	}
+
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	err := withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		result, err := orderCollection.UpdateOne(sessCtx, bson.M{"order_id": order.Order_id, "order_status": order.Order_status, "table_id": order.Table_id}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "table_id", Value: tableId},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errOrderChanged
+		}
+
+		if err = takeTable(sessCtx, tableId, origin, Updated_at); err != nil {
+			return err
+		}
+		if origin != nil {
+			_, err = tableCollection.UpdateOne(sessCtx, bson.M{"table_id": origin.Table_id}, bson.D{
+				{Key: "$set", Value: vacatedTable(Updated_at)},
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return order, err
+	}
+
+	order.Table_id = &tableId
+	order.Updated_at = Updated_at
+	syncTableStatus(ctx, order)
+	if orderIsInKitchen(order) {
+		publishOrderItems(ctx, models.KitchenEventItemUpdated, order)
+	}
+	return order, nil
+}
+
+// takeTable seats a party at a free or reserved table inside a transaction,
+// carrying over the guests and seating time of the table it comes from. As
+// in seatParty, it takes the table's booking lock and refuses a table booked
+// before the party would have had a whole turn.
+func takeTable(sessCtx mongo.SessionContext, tableId string, from *models.Table, Updated_at time.Time) error {
+	now := time.Now()
+	if err := lockTableBookings(sessCtx, tableId); err != nil {
+		return err
+	}
+	booking, err := nextBooking(sessCtx, tableId, now, now.Add(averageTurnMinutes*time.Minute), "")
+	if err != nil {
+		return err
+	}
+	if booking != nil {
+		return &tableError{Status: http.StatusConflict, Code: "TABLE_BOOKED", Message: fmt.Sprintf("the table is booked from %s", booking.Reserved_for.In(restaurantLocation).Format("15:04"))}
+	}
+
+	seatedAt := now
+	guests := 0
+	if from != nil {
+		if from.Seated_at != nil {
+			seatedAt = *from.Seated_at
+		}
+		if from.Number_of_guests != nil {
+			guests = *from.Number_of_guests
+		}
+	}
+
+	result, err := tableCollection.UpdateOne(sessCtx, bson.M{
+		"table_id":     tableId,
+		"table_status": bson.M{"$in": bson.A{nil, models.TableStatusFree, models.TableStatusReserved}},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "table_status", Value: models.TableStatusSeated},
+			{Key: "number_of_guests", Value: guests},
+			{Key: "seated_at", Value: seatedAt},
+			{Key: "held_reservation_id", Value: nil},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &tableError{Status: http.StatusConflict, Code: "TABLE_OCCUPIED", Message: "the table is not free"}
+	}
+	return nil
+}
+
+// vacatedTable is the update of a table its party left.
+func vacatedTable(Updated_at time.Time) bson.D {
+	return bson.D{
+		{Key: "table_status", Value: models.TableStatusNeedsCleaning},
+		{Key: "number_of_guests", Value: 0},
+		{Key: "seated_at", Value: nil},
+		{Key: "session_id", Value: nil},
+		{Key: "session_expires_at", Value: nil},
+		{Key: "updated_at", Value: Updated_at},
+	}
+}
+
+// checkOrderUnpaid refuses to move the items of an order payments were
+// taken on: its invoices would no longer match what was paid. It runs in the
+// transaction moving the items, after it wrote the order, so a payment or an
+// invoice created meanwhile conflicts with it.
+func checkOrderUnpaid(ctx context.Context, orderId string) error {
+	count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "payment_status": bson.M{"$nin": bson.A{nil, models.InvoiceStatusPending}}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &tableError{Status: http.StatusConflict, Code: "ORDER_ALREADY_PAID", Message: "payments were taken on the order, refund them first"}
+	}
+	return nil
+}
+
+// movableOrder loads an order that can still be moved, merged or split:
+// one that has not been billed, closed or cancelled.
+func movableOrder(ctx context.Context, orderId string) (models.Order, error) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, &tableError{Status: http.StatusNotFound, Code: "ORDER_NOT_FOUND", Message: "order was not found"}
+	}
+	if err != nil {
+		return order, err
+	}
+	switch orderStatus(order) {
+	case models.OrderStatusOpen, models.OrderStatusFired, models.OrderStatusReady, models.OrderStatusServed:
+		return order, nil
+	}
+	return order, &tableError{Status: http.StatusConflict, Code: "ORDER_NOT_MOVABLE", Message: fmt.Sprintf("a %s order cannot be moved", orderStatus(order))}
+}
+
+func orderItemsOf(ctx context.Context, filter bson.M) ([]models.OrderItem, error) {
+	result, err := orderItemCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	orderItems := []models.OrderItem{}
+	if err = result.All(ctx, &orderItems); err != nil {
+		return nil, err
+	}
+	return orderItems, nil
+}
+
+func orderItemIds(orderItems []models.OrderItem) bson.A {
+	ids := bson.A{}
+	for _, orderItem := range orderItems {
+		ids = append(ids, orderItem.Order_item_id)
+	}
+	return ids
+}
+
+// withTransaction runs fn in a MongoDB transaction, retrying it on transient
+// errors. Transactions need the database to run as a replica set.
+func withTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
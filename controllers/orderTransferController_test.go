@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"restaurant-management/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertTestItems stores an item of each unit price on an order and removes
+// them, and the orders split off it, when the test ends.
+func insertTestItems(t *testing.T, orderId string, prices ...float64) []models.OrderItem {
+	t.Helper()
+	var items []models.OrderItem
+	for _, price := range prices {
+		quantity := 1
+		unitPrice := price
+		foodId := primitive.NewObjectID().Hex()
+		item := models.OrderItem{ID: primitive.NewObjectID(), Order_id: orderId, Quantity: &quantity, Unit_price: &unitPrice, Food_id: &foodId}
+		item.Order_item_id = item.ID.Hex()
+		if _, err := orderItemCollection.InsertOne(context.Background(), item); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	t.Cleanup(func() {
+		orderItemCollection.DeleteMany(context.Background(), bson.M{"order_item_id": bson.M{"$in": orderItemIds(items)}})
+		orderCollection.DeleteMany(context.Background(), bson.M{"split_from": orderId})
+	})
+	return items
+}
+
+func invoicesOf(t *testing.T, orderId string) []models.Invoice {
+	t.Helper()
+	result, err := invoiceCollection.Find(context.Background(), bson.M{"order_id": orderId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices := []models.Invoice{}
+	if err = result.All(context.Background(), &invoices); err != nil {
+		t.Fatal(err)
+	}
+	return invoices
+}
+
+func TestSplitOrderInvoices(t *testing.T) {
+	requireTransactions(t)
+
+	tests := []struct {
+		name       string
+		paid       bool
+		wantStatus int
+	}{
+		{name: "pending invoice is recomputed", wantStatus: http.StatusOK},
+		{name: "paid order is not split", paid: true, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := insertTestOrder(t, models.OrderStatusServed)
+			items := insertTestItems(t, order.Order_id, 10, 4)
+			if w := performJSON(CreateInvoice(), gin.H{"order_id": order.Order_id}); w.Code != http.StatusOK {
+				t.Fatalf("invoice: status %d: %s", w.Code, w.Body)
+			}
+			before := invoicesOf(t, order.Order_id)[0]
+			if tt.paid {
+				paid := models.InvoiceStatusPartiallyPaid
+				invoiceCollection.UpdateOne(context.Background(), bson.M{"invoice_id": before.Invoice_id}, bson.M{"$set": bson.M{"payment_status": paid}})
+			}
+
+			path := "/orders/" + order.Order_id + "/split"
+			w := performAs(models.RoleWaiter, "/orders/:order_id/split", path, SplitOrder(), gin.H{"order_item_ids": []string{items[1].Order_item_id}})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			after := invoicesOf(t, order.Order_id)
+			if len(after) != 1 {
+				t.Fatalf("%d invoices after the split", len(after))
+			}
+			want := before.Total
+			if tt.wantStatus == http.StatusOK {
+				bill, _, err := billOrder(context.Background(), order.Order_id, 0, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = bill.Total
+				if want >= before.Total {
+					t.Errorf("the bill did not shrink: %d, was %d", want, before.Total)
+				}
+			}
+			if after[0].Total != want {
+				t.Errorf("invoice total %d, want %d", after[0].Total, want)
+			}
+		})
+	}
+}
+
+func TestMergeOrdersInvoices(t *testing.T) {
+	requireTransactions(t)
+
+	tableId := primitive.NewObjectID().Hex()
+	target := insertTestOrderAt(t, models.OrderStatusServed, tableId)
+	source := insertTestOrderAt(t, models.OrderStatusServed, tableId)
+	insertTestItems(t, target.Order_id, 10)
+	insertTestItems(t, source.Order_id, 4)
+	for _, order := range []models.Order{target, source} {
+		if w := performJSON(CreateInvoice(), gin.H{"order_id": order.Order_id}); w.Code != http.StatusOK {
+			t.Fatalf("invoice: status %d: %s", w.Code, w.Body)
+		}
+	}
+	before := invoicesOf(t, target.Order_id)[0]
+
+	path := "/orders/" + target.Order_id + "/merge"
+	w := performAs(models.RoleWaiter, "/orders/:order_id/merge", path, MergeOrders(), gin.H{"source_order_id": source.Order_id})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	if invoices := invoicesOf(t, source.Order_id); len(invoices) != 0 {
+		t.Errorf("the merged order kept %d payable invoices", len(invoices))
+	}
+	after := invoicesOf(t, target.Order_id)
+	if len(after) != 1 {
+		t.Fatalf("%d invoices after the merge", len(after))
+	}
+	bill, _, err := billOrder(context.Background(), target.Order_id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after[0].Total != bill.Total || bill.Total <= before.Total {
+		t.Errorf("invoice total %d, bill %d, was %d", after[0].Total, bill.Total, before.Total)
+	}
+}
+
+func TestTransferOrderBookings(t *testing.T) {
+	requireTransactions(t)
+
+	tests := []struct {
+		name       string
+		reserved   bool
+		bookedIn   time.Duration
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "free table", wantStatus: http.StatusOK},
+		{name: "table held for a stale booking", reserved: true, wantStatus: http.StatusOK},
+		{name: "table booked before a whole turn", bookedIn: 20 * time.Minute, wantStatus: http.StatusConflict, wantCode: "TABLE_BOOKED"},
+		{name: "table booked after a whole turn", bookedIn: (averageTurnMinutes + 30) * time.Minute, wantStatus: http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := insertTestTable(t, 9100+i, 4)
+			if tt.reserved {
+				tableCollection.UpdateOne(context.Background(), bson.M{"table_id": table.Table_id}, bson.M{"$set": bson.M{"table_status": models.TableStatusReserved, "held_reservation_id": "stale"}})
+			}
+			if tt.bookedIn != 0 {
+				status := models.ReservationStatusBooked
+				partySize := 2
+				reservedFor := time.Now().Add(tt.bookedIn)
+				endsAt := reservedFor.Add(time.Hour)
+				reservation := models.Reservation{ID: primitive.NewObjectID(), Party_size: &partySize, Reserved_for: &reservedFor, Ends_at: &endsAt, Table_id: &table.Table_id, Reservation_status: &status}
+				reservation.Reservation_id = reservation.ID.Hex()
+				if _, err := reservationCollection.InsertOne(context.Background(), reservation); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					reservationCollection.DeleteOne(context.Background(), bson.M{"reservation_id": reservation.Reservation_id})
+				})
+			}
+			order := insertTestOrder(t, models.OrderStatusServed)
+
+			path := "/orders/" + order.Order_id + "/transfer"
+			w := performAs(models.RoleWaiter, "/orders/:order_id/transfer", path, TransferOrder(), gin.H{"table_id": table.Table_id})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				var body struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+				}
+			}
+
+			var found models.Table
+			if err := tableCollection.FindOne(context.Background(), bson.M{"table_id": table.Table_id}).Decode(&found); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus == http.StatusOK && (*found.Table_status != models.TableStatusSeated || found.Held_reservation_id != nil) {
+				t.Errorf("table is %s held for %v", *found.Table_status, found.Held_reservation_id)
+			}
+			if tt.wantStatus != http.StatusOK && *found.Table_status == models.TableStatusSeated {
+				t.Error("the booked table was taken")
+			}
+		})
+	}
+}
+
+func TestMergeOrdersSourceTable(t *testing.T) {
+	requireTransactions(t)
+
+	target := insertTestOrderAt(t, models.OrderStatusServed, insertTestTable(t, 9201, 4).Table_id)
+	source := insertTestOrderAt(t, models.OrderStatusServed, primitive.NewObjectID().Hex())
+
+	path := "/orders/" + target.Order_id + "/merge"
+	w := performAs(models.RoleWaiter, "/orders/:order_id/merge", path, MergeOrders(), gin.H{"source_order_id": source.Order_id})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+
+	var found models.Order
+	if err := orderCollection.FindOne(context.Background(), bson.M{"order_id": source.Order_id}).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if orderStatus(found) != models.OrderStatusServed {
+		t.Errorf("the source order is %s", orderStatus(found))
+	}
+}
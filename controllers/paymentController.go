@@ -0,0 +1,488 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var paymentCollection *mongo.Collection = database.OpenCollection(database.Client, "payment")
+
+// GetPayments lists the payments of an invoice in the order they were made.
+func GetPayments() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		result, err := paymentCollection.Find(ctx, bson.M{"invoice_id": c.Param("invoice_id")}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing payments"})
+			return
+		}
+
+		allPayments := []bson.M{}
+		if err = result.All(ctx, &allPayments); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing payments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allPayments)
+	}
+}
+
+// CreatePayment records a tender paid against an invoice. Without an amount
+// the tender pays the rest of the balance. Cash may be tendered beyond the
+// balance, the difference being given back as change; other tenders may not
+// pay more than the balance. The invoice becomes PARTIALLY_PAID, then PAID
+// once its balance reaches zero; an invoice with nothing to pay, e.g. one
+// whose items were all comped, is closed with a payment of zero. Card
+// payments are authorized and captured through the payment provider first,
+// and refunded again if they cannot be recorded.
+func CreatePayment() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var payment models.Payment
+		if err := c.BindJSON(&payment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(payment); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": c.Param("invoice_id")}).Decode(&invoice)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+
+		if invoice.Currency == "" {
+			if invoice, err = computeInvoice(ctx, invoice); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while computing the invoice"})
+				return
+			}
+		}
+		if err := tenderPayment(&payment, invoice); err != nil {
+			respondInvoiceError(c, err)
+			return
+		}
+
+		payment.Kind = models.PaymentKindPayment
+		payment.Refund_of, payment.Reason, payment.Approved_by = nil, nil, nil
+		// Only chargeCard sets the provider of a payment: a transaction id sent
+		// by the client would have its refunds go to someone else's card.
+		payment.Provider, payment.Provider_transaction_id = nil, nil
+		payment.Invoice_id = invoice.Invoice_id
+		payment.Order_id = invoice.Order_id
+		payment.Created_by = c.GetString("user_id")
+		payment.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		payment.ID = primitive.NewObjectID()
+		payment.Payment_id = payment.ID.Hex()
+
+		if payment.Tender == models.TenderCard && payment.Amount > 0 {
+			if err := chargeCard(ctx, invoice, &payment); err != nil {
+				respondInvoiceError(c, err)
+				return
+			}
+		}
+
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			var err error
+			invoice, err = applyPayment(sessCtx, invoice, payment.Amount)
+			if err != nil {
+				return err
+			}
+			_, err = paymentCollection.InsertOne(sessCtx, payment)
+			return err
+		})
+		if err != nil {
+			if payment.Provider_transaction_id != nil {
+				if _, refundErr := refundCard(ctx, payment.Invoice_id, payment, payment.Amount, "reverse:"+payment.Payment_id); refundErr != nil {
+					log.Printf("payment %s of invoice %s was captured as %s but neither recorded nor reversed, it must be refunded by hand: %v (recording failed with: %v)", payment.Payment_id, payment.Invoice_id, *payment.Provider_transaction_id, refundErr, err)
+				}
+			}
+			respondInvoiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"payment": payment, "invoice": invoice, "balance": invoiceBalance(invoice)})
+	}
+}
+
+// RefundPayment refunds some or, by default, all that is left of a payment
+// of a settled invoice. The refund is recorded as a reversal entry with a
+// negative amount pointing back to the payment, which itself is left as it
+// was. It needs a reason code and a manager's approval. Card payments are
+// refunded through the payment provider before the refund is recorded.
+func RefundPayment() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Amount   int64            `json:"amount" validate:"min=0"`
+			Reason   string           `json:"reason" validate:"required"`
+			Note     *string          `json:"note" validate:"omitempty,max=500"`
+			Approval *models.Approval `json:"approval"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if validationErr := validate.Var(body.Reason, adjustmentReasons); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown reason code " + body.Reason, "code": "UNKNOWN_REASON"})
+			return
+		}
+
+		var payment models.Payment
+		err := paymentCollection.FindOne(ctx, bson.M{"payment_id": c.Param("payment_id"), "invoice_id": c.Param("invoice_id")}).Decode(&payment)
+		if err != nil || payment.Kind == models.PaymentKindRefund {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment was not found"})
+			return
+		}
+
+		approvedBy, err := managerApproval(ctx, c, body.Approval)
+		if err != nil {
+			respondApprovalError(c, err)
+			return
+		}
+
+		refund := models.Payment{
+			Invoice_id:  payment.Invoice_id,
+			Order_id:    payment.Order_id,
+			Tender:      payment.Tender,
+			Reference:   payment.Reference,
+			Kind:        models.PaymentKindRefund,
+			Refund_of:   &payment.Payment_id,
+			Reason:      &body.Reason,
+			Note:        body.Note,
+			Approved_by: &approvedBy,
+			Created_by:  c.GetString("user_id"),
+		}
+		refund.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		refund.ID = primitive.NewObjectID()
+		refund.Payment_id = refund.ID.Hex()
+
+		// The refund is checked before the provider is asked for it, then
+		// again along with recording it.
+		invoice, amount, err := refundCheck(ctx, payment, body.Amount)
+		if err != nil {
+			respondInvoiceError(c, err)
+			return
+		}
+		if payment.Provider_transaction_id != nil {
+			result, err := refundCard(ctx, invoice.Invoice_id, payment, amount, refund.Payment_id)
+			if err != nil {
+				respondInvoiceError(c, err)
+				return
+			}
+			refund.Provider = payment.Provider
+			refund.Provider_transaction_id = &result.Transaction_id
+		}
+		refund.Amount = -amount
+
+		err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			var err error
+			if invoice, _, err = refundCheck(sessCtx, payment, amount); err != nil {
+				return err
+			}
+			if invoice, err = applyRefund(sessCtx, invoice, amount); err != nil {
+				return err
+			}
+			_, err = paymentCollection.InsertOne(sessCtx, refund)
+			return err
+		})
+		if err != nil {
+			if refund.Provider_transaction_id != nil {
+				log.Printf("refund %s of payment %s went through the provider but was not recorded: %v", *refund.Provider_transaction_id, payment.Payment_id, err)
+			}
+			respondInvoiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"refund": refund, "invoice": invoice})
+	}
+}
+
+// refundCheck loads the invoice of a payment and works out how much of the
+// payment to refund: amount, or all that is left of it when zero.
+func refundCheck(ctx context.Context, payment models.Payment, amount int64) (models.Invoice, int64, error) {
+	var invoice models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": payment.Invoice_id}).Decode(&invoice); err != nil {
+		return invoice, 0, err
+	}
+	status := models.InvoiceStatusPending
+	if invoice.Payment_status != nil {
+		status = *invoice.Payment_status
+	}
+	if status != models.InvoiceStatusPaid && status != models.InvoiceStatusPartRefunded {
+		return invoice, 0, &invoiceError{Status: http.StatusConflict, Code: "INVOICE_NOT_PAID", Message: "only paid invoices are refunded"}
+	}
+
+	refundable, err := refundableAmount(ctx, payment)
+	if err != nil {
+		return invoice, 0, err
+	}
+	if refundable <= 0 {
+		return invoice, 0, &invoiceError{Status: http.StatusConflict, Code: "PAYMENT_REFUNDED", Message: "the payment was already refunded"}
+	}
+	if amount == 0 {
+		amount = refundable
+	}
+	if amount > refundable {
+		return invoice, 0, &invoiceError{Status: http.StatusBadRequest, Code: "REFUND_EXCEEDS_PAYMENT", Message: "the refund is more than what is left of the payment"}
+	}
+	return invoice, amount, nil
+}
+
+// refundableAmount is what is left to refund of a payment.
+func refundableAmount(ctx context.Context, payment models.Payment) (int64, error) {
+	result, err := paymentCollection.Find(ctx, bson.M{"refund_of": payment.Payment_id})
+	if err != nil {
+		return 0, err
+	}
+	var refunds []models.Payment
+	if err = result.All(ctx, &refunds); err != nil {
+		return 0, err
+	}
+
+	refundable := payment.Amount
+	for _, refund := range refunds {
+		refundable += refund.Amount
+	}
+	return refundable, nil
+}
+
+// applyRefund adds amount to what was refunded on an invoice and updates its
+// status, conditional on the amount refunded read beforehand.
+func applyRefund(ctx context.Context, invoice models.Invoice, amount int64) (models.Invoice, error) {
+	refunded := invoice.Amount_refunded + amount
+	status := models.InvoiceStatusPartRefunded
+	if refunded >= invoice.Amount_paid {
+		status = models.InvoiceStatusRefunded
+	}
+
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{"invoice_id": invoice.Invoice_id, "amount_refunded": storedAmount(invoice.Amount_refunded)}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := invoiceCollection.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "amount_refunded", Value: refunded},
+			{Key: "payment_status", Value: status},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+	}, opts).Decode(&invoice)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return invoice, &invoiceError{Status: http.StatusConflict, Code: "INVOICE_CHANGED", Message: "the invoice changed meanwhile, try again"}
+	}
+	return invoice, err
+}
+
+// tenderPayment settles the amount of a payment against the balance of an
+// invoice and works out the change of a cash payment.
+func tenderPayment(payment *models.Payment, invoice models.Invoice) error {
+	balance := invoiceBalance(invoice)
+	if balance <= 0 {
+		// Nothing is due on a pending invoice whose total is zero: a zero
+		// payment closes it.
+		pending := invoice.Payment_status == nil || *invoice.Payment_status == models.InvoiceStatusPending
+		if pending && invoice.Total == 0 && payment.Amount == 0 {
+			payment.Tendered, payment.Change = 0, 0
+			return nil
+		}
+		return &invoiceError{Status: http.StatusConflict, Code: "INVOICE_PAID", Message: "the invoice is already paid"}
+	}
+
+	if payment.Tender != models.TenderCash {
+		payment.Tendered, payment.Change = 0, 0
+		if payment.Amount == 0 {
+			payment.Amount = balance
+		}
+		if payment.Amount > balance {
+			return &invoiceError{Status: http.StatusBadRequest, Code: "OVERPAYMENT", Message: "the payment is more than the balance of the invoice"}
+		}
+		return nil
+	}
+
+	if payment.Amount == 0 {
+		payment.Amount = balance
+		if payment.Tendered > 0 {
+			payment.Amount = min(payment.Tendered, balance)
+		}
+	}
+	if payment.Tendered == 0 {
+		payment.Tendered = payment.Amount
+	}
+	if payment.Amount > balance {
+		return &invoiceError{Status: http.StatusBadRequest, Code: "OVERPAYMENT", Message: "the payment is more than the balance of the invoice"}
+	}
+	if payment.Tendered < payment.Amount {
+		return &invoiceError{Status: http.StatusBadRequest, Code: "INSUFFICIENT_TENDER", Message: "the cash tendered does not cover the payment"}
+	}
+	payment.Change = payment.Tendered - payment.Amount
+	return nil
+}
+
+// applyPayment adds amount to what was paid on an invoice and updates its
+// status. The update is conditional on the amount paid and total read
+// beforehand, so two payments made at once cannot both settle the same
+// balance, nor a payment settle a total recomputed meanwhile.
+func applyPayment(ctx context.Context, invoice models.Invoice, amount int64) (models.Invoice, error) {
+	paid := invoice.Amount_paid + amount
+	status := models.InvoiceStatusPartiallyPaid
+	if paid >= invoice.Total {
+		status = models.InvoiceStatusPaid
+	}
+
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{"invoice_id": invoice.Invoice_id, "total": invoice.Total, "amount_paid": storedAmount(invoice.Amount_paid)}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "amount_paid", Value: paid},
+			{Key: "payment_status", Value: status},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return invoice, &invoiceError{Status: http.StatusConflict, Code: "INVOICE_CHANGED", Message: "the invoice changed meanwhile, try again"}
+	}
+	return invoice, err
+}
+
+// computeInvoice computes and stores the totals of an invoice created before
+// they were computed.
+func computeInvoice(ctx context.Context, invoice models.Invoice) (models.Invoice, error) {
+	bill, settings, err := billOrder(ctx, invoice.Order_id, invoice.Discount, invoice.Tip)
+	if err != nil {
+		return invoice, err
+	}
+	applyBill(&invoice, bill, settings)
+
+	filter := bson.M{"invoice_id": invoice.Invoice_id, "currency": bson.M{"$in": bson.A{"", nil}}}
+	_, err = invoiceCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: billFields(invoice)}})
+	if err != nil {
+		return invoice, err
+	}
+	err = invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoice.Invoice_id}).Decode(&invoice)
+	return invoice, err
+}
+
+// storedAmount matches an amount or counter as stored, zero also matching
+// documents created before the field existed.
+func storedAmount(amount int64) interface{} {
+	if amount == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return amount
+}
+
+// paymentProvider is the gateway card payments go through.
+var paymentProvider helper.PaymentProvider = helper.NewPaymentProvider()
+
+// chargeCard authorizes and captures a card payment through the payment
+// provider, the payment id serving as idempotency key. An authorization that
+// cannot be captured is voided.
+func chargeCard(ctx context.Context, invoice models.Invoice, payment *models.Payment) error {
+	result, err := paymentProvider.Authorize(ctx, helper.AuthorizeRequest{
+		Amount:          payment.Amount,
+		Currency:        invoice.Currency,
+		Card_token:      *payment.Card_token,
+		Reference:       invoice.Invoice_id,
+		Idempotency_key: payment.Payment_id,
+	})
+	recordProviderTransaction(ctx, invoice.Invoice_id, models.ProviderOperationAuthorize, payment.Payment_id, payment.Amount, result, err)
+	if err = providerError(result, err); err != nil {
+		return err
+	}
+
+	captured, err := paymentProvider.Capture(ctx, result.Transaction_id, payment.Amount, payment.Payment_id)
+	recordProviderTransaction(ctx, invoice.Invoice_id, models.ProviderOperationCapture, payment.Payment_id, payment.Amount, captured, err)
+	if err = providerError(captured, err); err != nil {
+		voided, voidErr := paymentProvider.Void(ctx, result.Transaction_id, payment.Payment_id)
+		recordProviderTransaction(ctx, invoice.Invoice_id, models.ProviderOperationVoid, payment.Payment_id, payment.Amount, voided, voidErr)
+		return err
+	}
+
+	provider := paymentProvider.Name()
+	payment.Provider = &provider
+	payment.Provider_transaction_id = &result.Transaction_id
+	payment.Card_token = nil
+	return nil
+}
+
+// refundCard refunds amount of a card payment through the payment provider.
+func refundCard(ctx context.Context, invoiceId string, payment models.Payment, amount int64, idempotencyKey string) (helper.ProviderResult, error) {
+	result, err := paymentProvider.Refund(ctx, *payment.Provider_transaction_id, amount, idempotencyKey)
+	recordProviderTransaction(ctx, invoiceId, models.ProviderOperationRefund, payment.Payment_id, amount, result, err)
+	return result, providerError(result, err)
+}
+
+// providerError turns a provider failure or decline into the error to
+// answer with.
+func providerError(result helper.ProviderResult, err error) error {
+	switch {
+	case errors.Is(err, helper.ErrProviderTimeout):
+		return &invoiceError{Status: http.StatusGatewayTimeout, Code: "PROVIDER_TIMEOUT", Message: err.Error()}
+	case err != nil:
+		log.Println(err)
+		return &invoiceError{Status: http.StatusBadGateway, Code: "PROVIDER_ERROR", Message: "the payment provider refused the request"}
+	case result.Status == helper.ProviderDeclined:
+		return &invoiceError{Status: http.StatusPaymentRequired, Code: "PAYMENT_DECLINED", Message: "the payment was declined: " + result.Decline_code}
+	}
+	return nil
+}
+
+// recordProviderTransaction keeps a call made to the payment provider on the
+// invoice it was made for, whatever its outcome.
+func recordProviderTransaction(ctx context.Context, invoiceId string, operation string, paymentId string, amount int64, result helper.ProviderResult, err error) {
+	status := result.Status
+	switch {
+	case errors.Is(err, helper.ErrProviderTimeout):
+		status = models.ProviderStatusTimeout
+	case err != nil:
+		status = models.ProviderStatusError
+	}
+	transaction := models.ProviderTransaction{
+		Provider:       paymentProvider.Name(),
+		Operation:      operation,
+		Transaction_id: result.Transaction_id,
+		Status:         status,
+		Decline_code:   result.Decline_code,
+		Amount:         amount,
+		Payment_id:     paymentId,
+		Created_at:     time.Now(),
+	}
+
+	_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceId}, bson.D{
+		{Key: "$push", Value: bson.D{{Key: "provider_transactions", Value: transaction}}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
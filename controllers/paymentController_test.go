@@ -0,0 +1,474 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTenderPayment(t *testing.T) {
+	pending := models.InvoiceStatusPending
+	partiallyPaid := models.InvoiceStatusPartiallyPaid
+	paid := models.InvoiceStatusPaid
+
+	tests := []struct {
+		name     string
+		invoice  models.Invoice
+		payment  models.Payment
+		want     models.Payment
+		wantCode string
+	}{
+		{
+			name:    "cash pays the balance by default",
+			invoice: models.Invoice{Total: 2500, Payment_status: &pending},
+			payment: models.Payment{Tender: models.TenderCash},
+			want:    models.Payment{Amount: 2500, Tendered: 2500},
+		},
+		{
+			name:    "cash tendered beyond the balance gives change",
+			invoice: models.Invoice{Total: 2500, Amount_paid: 1000, Payment_status: &partiallyPaid},
+			payment: models.Payment{Tender: models.TenderCash, Tendered: 2000},
+			want:    models.Payment{Amount: 1500, Tendered: 2000, Change: 500},
+		},
+		{
+			name:    "cash tendered below the balance pays part of it",
+			invoice: models.Invoice{Total: 2500, Payment_status: &pending},
+			payment: models.Payment{Tender: models.TenderCash, Tendered: 1000},
+			want:    models.Payment{Amount: 1000, Tendered: 1000},
+		},
+		{
+			name:     "cash tendered below the amount",
+			invoice:  models.Invoice{Total: 2500, Payment_status: &pending},
+			payment:  models.Payment{Tender: models.TenderCash, Amount: 2000, Tendered: 1000},
+			wantCode: "INSUFFICIENT_TENDER",
+		},
+		{
+			name:     "card beyond the balance",
+			invoice:  models.Invoice{Total: 2500, Payment_status: &pending},
+			payment:  models.Payment{Tender: models.TenderCard, Amount: 3000},
+			wantCode: "OVERPAYMENT",
+		},
+		{
+			name:    "card ignores tendered",
+			invoice: models.Invoice{Total: 2500, Payment_status: &pending},
+			payment: models.Payment{Tender: models.TenderCard, Tendered: 5000},
+			want:    models.Payment{Amount: 2500},
+		},
+		{
+			name:     "paid invoice",
+			invoice:  models.Invoice{Total: 2500, Amount_paid: 2500, Payment_status: &paid},
+			payment:  models.Payment{Tender: models.TenderCash},
+			wantCode: "INVOICE_PAID",
+		},
+		{
+			name:    "zero payment closes a zero total invoice",
+			invoice: models.Invoice{Total: 0, Payment_status: &pending},
+			payment: models.Payment{Tender: models.TenderCash, Tendered: 500},
+			want:    models.Payment{},
+		},
+		{
+			name:    "zero payment closes a legacy invoice without status",
+			invoice: models.Invoice{Total: 0},
+			payment: models.Payment{Tender: models.TenderVoucher},
+			want:    models.Payment{},
+		},
+		{
+			name:     "zero total invoice takes no money",
+			invoice:  models.Invoice{Total: 0, Payment_status: &pending},
+			payment:  models.Payment{Tender: models.TenderCash, Amount: 100},
+			wantCode: "INVOICE_PAID",
+		},
+		{
+			name:     "closed zero total invoice",
+			invoice:  models.Invoice{Total: 0, Payment_status: &paid},
+			payment:  models.Payment{Tender: models.TenderCash},
+			wantCode: "INVOICE_PAID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := tt.payment
+			err := tenderPayment(&payment, tt.invoice)
+
+			if tt.wantCode != "" {
+				var invoiceErr *invoiceError
+				if !errors.As(err, &invoiceErr) || invoiceErr.Code != tt.wantCode {
+					t.Fatalf("tenderPayment() error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tenderPayment() error = %v", err)
+			}
+			if payment.Amount != tt.want.Amount || payment.Tendered != tt.want.Tendered || payment.Change != tt.want.Change {
+				t.Errorf("tenderPayment() amount %d, tendered %d, change %d; want %d, %d, %d",
+					payment.Amount, payment.Tendered, payment.Change, tt.want.Amount, tt.want.Tendered, tt.want.Change)
+			}
+		})
+	}
+}
+
+func TestProviderError(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     helper.ProviderResult
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "approved", result: helper.ProviderResult{Status: helper.ProviderApproved}},
+		{name: "declined", result: helper.ProviderResult{Status: helper.ProviderDeclined, Decline_code: "card_declined"}, wantStatus: http.StatusPaymentRequired, wantCode: "PAYMENT_DECLINED"},
+		{name: "timeout", err: helper.ErrProviderTimeout, wantStatus: http.StatusGatewayTimeout, wantCode: "PROVIDER_TIMEOUT"},
+		{name: "unknown transaction", err: helper.ErrUnknownTransaction, wantStatus: http.StatusBadGateway, wantCode: "PROVIDER_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := providerError(tt.result, tt.err)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("providerError() = %v, want nil", err)
+				}
+				return
+			}
+			var invoiceErr *invoiceError
+			if !errors.As(err, &invoiceErr) || invoiceErr.Status != tt.wantStatus || invoiceErr.Code != tt.wantCode {
+				t.Fatalf("providerError() = %v, want %d %s", err, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+// requireTransactions skips the test unless MongoDB is reachable and
+// supports transactions.
+func requireTransactions(t *testing.T) {
+	t.Helper()
+	requireDatabase(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := database.RequireTransactions(ctx); err != nil {
+		t.Skip(err)
+	}
+}
+
+// performAs runs handler, registered on route, on a POST request to path
+// made by a user with the given role.
+func performAs(role string, route string, path string, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST(route, func(c *gin.Context) {
+		c.Set("user_id", "test-"+role)
+		c.Set("role", role)
+	}, handler)
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// timeoutRefundProvider is the fake provider, except that refunds time out.
+type timeoutRefundProvider struct {
+	*helper.FakePaymentProvider
+}
+
+func (p timeoutRefundProvider) Refund(ctx context.Context, transactionId string, amount int64, idempotencyKey string) (helper.ProviderResult, error) {
+	return helper.ProviderResult{}, helper.ErrProviderTimeout
+}
+
+// insertTestInvoice stores a pending invoice of total and removes it and its
+// payments when the test ends.
+func insertTestInvoice(t *testing.T, total int64) models.Invoice {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := models.InvoiceStatusPending
+	invoice := models.Invoice{
+		ID:             primitive.NewObjectID(),
+		Order_id:       primitive.NewObjectID().Hex(),
+		Payment_status: &status,
+		Currency:       "USD",
+		Tax_mode:       models.TaxModeExclusive,
+		Subtotal:       total,
+		Taxes:          []models.InvoiceTax{},
+		Total:          total,
+	}
+	invoice.Invoice_id = invoice.ID.Hex()
+	if _, err := invoiceCollection.InsertOne(ctx, invoice); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		invoiceCollection.DeleteOne(context.Background(), bson.M{"invoice_id": invoice.Invoice_id})
+		paymentCollection.DeleteMany(context.Background(), bson.M{"invoice_id": invoice.Invoice_id})
+	})
+	return invoice
+}
+
+func findTestInvoice(t *testing.T, invoiceId string) models.Invoice {
+	t.Helper()
+	var invoice models.Invoice
+	if err := invoiceCollection.FindOne(context.Background(), bson.M{"invoice_id": invoiceId}).Decode(&invoice); err != nil {
+		t.Fatal(err)
+	}
+	return invoice
+}
+
+func TestCardPayments(t *testing.T) {
+	requireTransactions(t)
+
+	previous := paymentProvider
+	defer func() { paymentProvider = previous }()
+
+	tests := []struct {
+		name         string
+		token        string
+		wantStatus   int
+		wantCode     string
+		wantInvoice  string
+		wantPaid     int64
+		wantProvider []string
+	}{
+		{
+			name:         "approved",
+			token:        "tok_visa",
+			wantStatus:   http.StatusOK,
+			wantInvoice:  models.InvoiceStatusPaid,
+			wantPaid:     2500,
+			wantProvider: []string{helper.ProviderApproved, helper.ProviderApproved},
+		},
+		{
+			name:         "declined",
+			token:        helper.FakeCardDeclined,
+			wantStatus:   http.StatusPaymentRequired,
+			wantCode:     "PAYMENT_DECLINED",
+			wantInvoice:  models.InvoiceStatusPending,
+			wantProvider: []string{helper.ProviderDeclined},
+		},
+		{
+			name:         "insufficient funds",
+			token:        helper.FakeCardInsufficientFunds,
+			wantStatus:   http.StatusPaymentRequired,
+			wantCode:     "PAYMENT_DECLINED",
+			wantInvoice:  models.InvoiceStatusPending,
+			wantProvider: []string{helper.ProviderDeclined},
+		},
+		{
+			name:         "timeout",
+			token:        helper.FakeCardTimeout,
+			wantStatus:   http.StatusGatewayTimeout,
+			wantCode:     "PROVIDER_TIMEOUT",
+			wantInvoice:  models.InvoiceStatusPending,
+			wantProvider: []string{models.ProviderStatusTimeout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paymentProvider = helper.NewFakePaymentProvider()
+			invoice := insertTestInvoice(t, 2500)
+
+			path := "/invoices/" + invoice.Invoice_id + "/payments"
+			w := performAs(models.RoleCashier, "/invoices/:invoice_id/payments", path, CreatePayment(), gin.H{"tender": models.TenderCard, "card_token": tt.token})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				var body struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+				}
+			}
+
+			stored := findTestInvoice(t, invoice.Invoice_id)
+			if *stored.Payment_status != tt.wantInvoice || stored.Amount_paid != tt.wantPaid {
+				t.Errorf("invoice is %s with %d paid, want %s with %d paid", *stored.Payment_status, stored.Amount_paid, tt.wantInvoice, tt.wantPaid)
+			}
+			if len(stored.Provider_transactions) != len(tt.wantProvider) {
+				t.Fatalf("%d provider transactions recorded, want %d", len(stored.Provider_transactions), len(tt.wantProvider))
+			}
+			for i, status := range tt.wantProvider {
+				if stored.Provider_transactions[i].Status != status {
+					t.Errorf("provider transaction %d is %s, want %s", i, stored.Provider_transactions[i].Status, status)
+				}
+			}
+
+			count, err := paymentCollection.CountDocuments(context.Background(), bson.M{"invoice_id": invoice.Invoice_id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantCount := int64(0)
+			if tt.wantStatus == http.StatusOK {
+				wantCount = 1
+			}
+			if count != wantCount {
+				t.Errorf("%d payments recorded, want %d", count, wantCount)
+			}
+		})
+	}
+}
+
+func TestPaymentIgnoresProviderFields(t *testing.T) {
+	requireTransactions(t)
+
+	previous := paymentProvider
+	defer func() { paymentProvider = previous }()
+	paymentProvider = helper.NewFakePaymentProvider()
+
+	tests := []struct {
+		name         string
+		body         gin.H
+		wantProvider bool
+	}{
+		{
+			name: "cash",
+			body: gin.H{"tender": models.TenderCash, "provider": "fake", "provider_transaction_id": "txn_of_someone_else"},
+		},
+		{
+			name:         "card",
+			body:         gin.H{"tender": models.TenderCard, "card_token": "tok_visa", "provider_transaction_id": "txn_of_someone_else"},
+			wantProvider: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := insertTestInvoice(t, 2500)
+
+			path := "/invoices/" + invoice.Invoice_id + "/payments"
+			w := performAs(models.RoleCashier, "/invoices/:invoice_id/payments", path, CreatePayment(), tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var payment models.Payment
+			if err := paymentCollection.FindOne(context.Background(), bson.M{"invoice_id": invoice.Invoice_id}).Decode(&payment); err != nil {
+				t.Fatal(err)
+			}
+			if payment.Provider_transaction_id != nil && *payment.Provider_transaction_id == "txn_of_someone_else" {
+				t.Fatal("the transaction id sent by the client was stored")
+			}
+			if (payment.Provider_transaction_id != nil) != tt.wantProvider || (payment.Provider != nil) != tt.wantProvider {
+				t.Errorf("provider %v, transaction %v; want them set: %v", payment.Provider, payment.Provider_transaction_id, tt.wantProvider)
+			}
+		})
+	}
+}
+
+func TestCardRefunds(t *testing.T) {
+	requireTransactions(t)
+
+	previous := paymentProvider
+	defer func() { paymentProvider = previous }()
+
+	tests := []struct {
+		name        string
+		provider    func(*helper.FakePaymentProvider) helper.PaymentProvider
+		amount      int64
+		wantStatus  int
+		wantCode    string
+		wantInvoice string
+		wantRefund  int64
+	}{
+		{
+			name:        "full refund",
+			provider:    func(p *helper.FakePaymentProvider) helper.PaymentProvider { return p },
+			wantStatus:  http.StatusOK,
+			wantInvoice: models.InvoiceStatusRefunded,
+			wantRefund:  2500,
+		},
+		{
+			name:        "partial refund",
+			provider:    func(p *helper.FakePaymentProvider) helper.PaymentProvider { return p },
+			amount:      1000,
+			wantStatus:  http.StatusOK,
+			wantInvoice: models.InvoiceStatusPartRefunded,
+			wantRefund:  1000,
+		},
+		{
+			name:        "refund beyond the payment",
+			provider:    func(p *helper.FakePaymentProvider) helper.PaymentProvider { return p },
+			amount:      3000,
+			wantStatus:  http.StatusBadRequest,
+			wantInvoice: models.InvoiceStatusPaid,
+		},
+		{
+			name:        "provider timeout",
+			provider:    func(p *helper.FakePaymentProvider) helper.PaymentProvider { return timeoutRefundProvider{p} },
+			wantStatus:  http.StatusGatewayTimeout,
+			wantCode:    "PROVIDER_TIMEOUT",
+			wantInvoice: models.InvoiceStatusPaid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := helper.NewFakePaymentProvider()
+			paymentProvider = fake
+			invoice := insertTestInvoice(t, 2500)
+
+			path := "/invoices/" + invoice.Invoice_id + "/payments"
+			w := performAs(models.RoleCashier, "/invoices/:invoice_id/payments", path, CreatePayment(), gin.H{"tender": models.TenderCard, "card_token": "tok_visa"})
+			if w.Code != http.StatusOK {
+				t.Fatalf("payment: status %d: %s", w.Code, w.Body)
+			}
+			var paid struct{ Payment models.Payment }
+			if err := json.Unmarshal(w.Body.Bytes(), &paid); err != nil {
+				t.Fatal(err)
+			}
+
+			paymentProvider = tt.provider(fake)
+			path += "/" + paid.Payment.Payment_id + "/refund"
+			w = performAs(models.RoleManager, "/invoices/:invoice_id/payments/:payment_id/refund", path, RefundPayment(), gin.H{"amount": tt.amount, "reason": models.ReasonOvercharge})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("refund: status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode != "" {
+				var body struct{ Code string }
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+				}
+			}
+
+			stored := findTestInvoice(t, invoice.Invoice_id)
+			if *stored.Payment_status != tt.wantInvoice || stored.Amount_refunded != tt.wantRefund {
+				t.Errorf("invoice is %s with %d refunded, want %s with %d refunded", *stored.Payment_status, stored.Amount_refunded, tt.wantInvoice, tt.wantRefund)
+			}
+			if tt.wantRefund == 0 {
+				return
+			}
+
+			var refund models.Payment
+			err := paymentCollection.FindOne(context.Background(), bson.M{"refund_of": paid.Payment.Payment_id}).Decode(&refund)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refund.Amount != -tt.wantRefund || refund.Provider_transaction_id == nil {
+				t.Errorf("refund %+v, want %d through the provider", refund, -tt.wantRefund)
+			}
+
+			// The provider gave back exactly what was refunded.
+			result, err := fake.Refund(context.Background(), *paid.Payment.Provider_transaction_id, 2500-tt.wantRefund+1, "probe")
+			if err != nil || result.Status != helper.ProviderDeclined {
+				t.Errorf("provider accepted refunding more than was left: %+v, %v", result, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant-management/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// publicMenuMaxAge bounds how long guests may cache the public menu without
+// revalidating it. It is kept short because availability follows the 86 list
+// and portion counts, which do not move Last-Modified.
+const publicMenuMaxAge = 60
+
+type publicFood struct {
+	Food_id       string               `json:"food_id"`
+	Name          *string              `json:"name"`
+	Price         *float64             `json:"price"`
+	Food_image    *string              `json:"food_image"`
+	Variants      []models.FoodVariant `json:"variants"`
+	Option_groups []models.OptionGroup `json:"option_groups"`
+	Available     bool                 `json:"available"`
+}
+
+type publicBundle struct {
+	Bundle_id    string              `json:"bundle_id"`
+	Name         *string             `json:"name"`
+	Price        *float64            `json:"price"`
+	Bundle_image *string             `json:"bundle_image"`
+	Slots        []models.BundleSlot `json:"slots"`
+	Available    bool                `json:"available"`
+}
+
+type publicMenu struct {
+	Menu_id   string                `json:"menu_id"`
+	Name      string                `json:"name"`
+	Schedules []models.MenuSchedule `json:"schedules"`
+	Available bool                  `json:"available"`
+	Foods     []publicFood          `json:"foods"`
+	Bundles   []publicBundle        `json:"bundles"`
+}
+
+type publicMenuCategory struct {
+	Category string       `json:"category"`
+	Menus    []publicMenu `json:"menus"`
+}
+
+// GetPublicMenu serves the menu and its bundles to guests without
+// credentials, grouped by category. Responses carry an ETag and Last-Modified
+// so QR code clients can revalidate cheaply; both move when a menu opens or
+// closes.
+func GetPublicMenu() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		categories, lastModified, err := buildPublicMenu(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu"})
+			return
+		}
+
+		body, err := json.Marshal(gin.H{"categories": categories})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu"})
+			return
+		}
+		sum := sha256.Sum256(append(body, lastModified.UTC().Format(time.RFC3339)...))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", publicMenuMaxAge))
+		if !lastModified.IsZero() {
+			c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(c, etag, lastModified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// notModified applies the conditional request headers. If-None-Match wins
+// over If-Modified-Since when both are sent.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		sinceTime, err := http.ParseTime(since)
+		if err == nil && !lastModified.Truncate(time.Second).After(sinceTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPublicMenu groups every menu with its foods and bundles by category,
+// flagging what can be ordered at the given instant, and returns the last
+// time any of them changed, counting a menu opening or closing as a change.
+func buildPublicMenu(ctx context.Context, check time.Time) ([]publicMenuCategory, time.Time, error) {
+	var lastModified time.Time
+
+	result, err := menuCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, lastModified, err
+	}
+	var menus []models.Menu
+	if err = result.All(ctx, &menus); err != nil {
+		return nil, lastModified, err
+	}
+
+	result, err = foodCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, lastModified, err
+	}
+	var foods []models.Food
+	if err = result.All(ctx, &foods); err != nil {
+		return nil, lastModified, err
+	}
+
+	result, err = bundleCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, lastModified, err
+	}
+	var bundles []models.Bundle
+	if err = result.All(ctx, &bundles); err != nil {
+		return nil, lastModified, err
+	}
+
+	foodsById := map[string]models.Food{}
+	foodsByMenu := map[string][]models.Food{}
+	for _, food := range foods {
+		foodsById[food.Food_id] = food
+		if food.Menu_id == nil {
+			continue
+		}
+		foodsByMenu[*food.Menu_id] = append(foodsByMenu[*food.Menu_id], food)
+	}
+
+	bundlesByMenu := map[string][]models.Bundle{}
+	for _, bundle := range bundles {
+		if bundle.Menu_id == nil {
+			continue
+		}
+		bundlesByMenu[*bundle.Menu_id] = append(bundlesByMenu[*bundle.Menu_id], bundle)
+	}
+
+	byCategory := map[string][]publicMenu{}
+	for _, menu := range menus {
+		if menu.Updated_at.After(lastModified) {
+			lastModified = menu.Updated_at
+		}
+		if opened := menuWindowStart(menu, check); opened.After(lastModified) {
+			lastModified = opened
+		}
+		view := publicMenu{
+			Menu_id:   menu.Menu_id,
+			Name:      menu.Name,
+			Schedules: menu.Schedules,
+			Available: menuIsActive(menu, check),
+			Foods:     []publicFood{},
+			Bundles:   []publicBundle{},
+		}
+		for _, food := range foodsByMenu[menu.Menu_id] {
+			if food.Updated_at.After(lastModified) {
+				lastModified = food.Updated_at
+			}
+			view.Foods = append(view.Foods, publicFood{
+				Food_id:       food.Food_id,
+				Name:          food.Name,
+				Price:         food.Price,
+				Food_image:    food.Food_image,
+				Variants:      food.Variants,
+				Option_groups: food.Option_groups,
+				Available:     view.Available && !foodSoldOut(food),
+			})
+		}
+		sort.Slice(view.Foods, func(i, j int) bool {
+			return publicName(view.Foods[i].Name) < publicName(view.Foods[j].Name)
+		})
+		for _, bundle := range bundlesByMenu[menu.Menu_id] {
+			if bundle.Updated_at.After(lastModified) {
+				lastModified = bundle.Updated_at
+			}
+			view.Bundles = append(view.Bundles, publicBundle{
+				Bundle_id:    bundle.Bundle_id,
+				Name:         bundle.Name,
+				Price:        bundle.Price,
+				Bundle_image: bundle.Bundle_image,
+				Slots:        bundle.Slots,
+				Available:    view.Available && bundleOrderable(bundle, foodsById),
+			})
+		}
+		sort.Slice(view.Bundles, func(i, j int) bool {
+			return publicName(view.Bundles[i].Name) < publicName(view.Bundles[j].Name)
+		})
+		byCategory[menu.Category] = append(byCategory[menu.Category], view)
+	}
+
+	categories := []publicMenuCategory{}
+	for category, views := range byCategory {
+		sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+		categories = append(categories, publicMenuCategory{Category: category, Menus: views})
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Category < categories[j].Category })
+
+	return categories, lastModified, nil
+}
+
+// bundleOrderable reports whether every slot of a bundle can be filled with
+// a food that is not sold out, by default or by substitution.
+func bundleOrderable(bundle models.Bundle, foodsById map[string]models.Food) bool {
+	for _, slot := range bundle.Slots {
+		filled := false
+		for _, foodId := range append([]string{*slot.Default_food_id}, slot.Allowed_food_ids...) {
+			if food, ok := foodsById[foodId]; ok && !foodSoldOut(food) {
+				filled = true
+				break
+			}
+		}
+		if !filled {
+			return false
+		}
+	}
+	return true
+}
+
+// menuWindowStart returns the last instant, at or before check, at which a
+// menu opened or closed according to its dates and schedules, or the zero
+// time if it never did.
+func menuWindowStart(menu models.Menu, check time.Time) time.Time {
+	var last time.Time
+	consider := func(t time.Time) {
+		if !t.After(check) && t.After(last) {
+			last = t
+		}
+	}
+	if menu.Start_Date != nil {
+		consider(*menu.Start_Date)
+	}
+	if menu.End_Date != nil {
+		consider(*menu.End_Date)
+	}
+
+	local := check.In(restaurantLocation)
+	for _, schedule := range menu.Schedules {
+		start, errStart := time.Parse("15:04", schedule.Start)
+		end, errEnd := time.Parse("15:04", schedule.End)
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+		// A week back always reaches the last boundary of a weekly schedule.
+		for back := 0; back <= 7; back++ {
+			day := local.AddDate(0, 0, -back)
+			if !scheduledOn(schedule, weekdays[day.Weekday()]) {
+				continue
+			}
+			opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, restaurantLocation)
+			closes := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, restaurantLocation)
+			if !closes.After(opens) {
+				closes = closes.AddDate(0, 0, 1)
+			}
+			consider(opens)
+			consider(closes)
+		}
+	}
+	return last
+}
+
+func publicName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
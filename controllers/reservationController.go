@@ -0,0 +1,540 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var reservationCollection *mongo.Collection = database.OpenCollection(database.Client, "reservation")
+
+// GetReservations lists reservations by time, optionally of one day
+// (YYYY-MM-DD in the restaurant's timezone) and one status.
+func GetReservations() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if date := c.Query("date"); date != "" {
+			day, err := time.ParseInLocation("2006-01-02", date, restaurantLocation)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be formatted as YYYY-MM-DD"})
+				return
+			}
+			filter["reserved_for"] = bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}
+		}
+		if status := c.Query("status"); status != "" {
+			filter["reservation_status"] = status
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "reserved_for", Value: 1}})
+		result, err := reservationCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing reservations"})
+			return
+		}
+		allReservations := []models.Reservation{}
+		if err = result.All(ctx, &allReservations); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing reservations"})
+			return
+		}
+		c.JSON(http.StatusOK, allReservations)
+	}
+}
+
+func GetReservation() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reservation models.Reservation
+		err := reservationCollection.FindOne(ctx, bson.M{"reservation_id": c.Param("reservation_id")}).Decode(&reservation)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reservation was not found"})
+			return
+		}
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+// SuggestTables lists the tables that can take a party at a given time,
+// smallest first.
+func SuggestTables() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		partySize, err := strconv.Atoi(c.Query("party_size"))
+		if err != nil || partySize < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "party_size must be a positive number"})
+			return
+		}
+		start, err := time.Parse(time.RFC3339, c.Query("reserved_for"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reserved_for must be an RFC 3339 time"})
+			return
+		}
+		minutes := models.DefaultReservationMinutes
+		if value := c.Query("duration_minutes"); value != "" {
+			if minutes, err = strconv.Atoi(value); err != nil || minutes < 15 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duration_minutes must be at least 15"})
+				return
+			}
+		}
+
+		tables, err := availableTables(ctx, partySize, start, start.Add(time.Duration(minutes)*time.Minute), "")
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while suggesting tables"})
+			return
+		}
+		c.JSON(http.StatusOK, tables)
+	}
+}
+
+func CreateReservation() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reservation models.Reservation
+		if err := c.BindJSON(&reservation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(reservation); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		reservation.ID = primitive.NewObjectID()
+		reservation.Reservation_id = reservation.ID.Hex()
+		status := models.ReservationStatusBooked
+		reservation.Reservation_status = &status
+		reservation.Created_by = c.GetString("user_id")
+		reservation.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		reservation.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		requestedTable := reservation.Table_id
+		err := withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			reservation.Table_id = requestedTable
+			if err := bookTable(sessCtx, &reservation); err != nil {
+				return err
+			}
+			_, err := reservationCollection.InsertOne(sessCtx, reservation)
+			return err
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		holdReservedTables(ctx, time.Now())
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+// UpdateReservation changes a booking or cancels it. Moving it, resizing the
+// party or changing its table checks the table again.
+func UpdateReservation() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reservation models.Reservation
+		if err := c.BindJSON(&reservation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		reservationId := c.Param("reservation_id")
+
+		var found models.Reservation
+		if err := reservationCollection.FindOne(ctx, bson.M{"reservation_id": reservationId}).Decode(&found); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reservation was not found"})
+			return
+		}
+		if *found.Reservation_status != models.ReservationStatusBooked {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a %s reservation cannot be changed", *found.Reservation_status)})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if reservation.Reservation_status != nil {
+			switch *reservation.Reservation_status {
+			case models.ReservationStatusCancelled, models.ReservationStatusNoShow:
+				updateObj = append(updateObj, bson.E{Key: "reservation_status", Value: reservation.Reservation_status})
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "reservation_status can only be set to CANCELLED or NO_SHOW, seat the party to mark it SEATED"})
+				return
+			}
+		}
+
+		rebook := reservation.Party_size != nil || reservation.Reserved_for != nil || reservation.Duration_minutes != 0 || reservation.Table_id != nil
+		if rebook {
+			if reservation.Party_size == nil {
+				reservation.Party_size = found.Party_size
+			}
+			if reservation.Reserved_for == nil {
+				reservation.Reserved_for = found.Reserved_for
+			}
+			if reservation.Duration_minutes == 0 {
+				reservation.Duration_minutes = found.Duration_minutes
+			}
+			if reservation.Table_id == nil {
+				reservation.Table_id = found.Table_id
+			}
+			if validationErr := validate.StructPartial(reservation, "Party_size", "Duration_minutes"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			reservation.Reservation_id = reservationId
+		}
+		if reservation.Contact_name != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_name", Value: reservation.Contact_name})
+		}
+		if reservation.Contact_phone != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_phone", Value: reservation.Contact_phone})
+		}
+		if reservation.Contact_email != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_email", Value: reservation.Contact_email})
+		}
+		if reservation.Notes != nil {
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: reservation.Notes})
+		}
+
+		reservation.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: reservation.Updated_at})
+
+		// Rebooking checks the table and saves the change in one transaction
+		// holding the table's booking lock.
+		var updated models.Reservation
+		requestedTable := reservation.Table_id
+		err := withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			fields := updateObj
+			if rebook {
+				reservation.Table_id = requestedTable
+				if err := bookTable(sessCtx, &reservation); err != nil {
+					return err
+				}
+				fields = append(fields[:len(fields):len(fields)],
+					bson.E{Key: "party_size", Value: reservation.Party_size},
+					bson.E{Key: "reserved_for", Value: reservation.Reserved_for},
+					bson.E{Key: "duration_minutes", Value: reservation.Duration_minutes},
+					bson.E{Key: "ends_at", Value: reservation.Ends_at},
+					bson.E{Key: "table_id", Value: reservation.Table_id},
+				)
+			}
+
+			opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+			return reservationCollection.FindOneAndUpdate(sessCtx,
+				bson.M{"reservation_id": reservationId, "reservation_status": models.ReservationStatusBooked},
+				bson.D{{Key: "$set", Value: fields}},
+				opts,
+			).Decode(&updated)
+		})
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusConflict, gin.H{"error": "reservation was changed by someone else, reload and try again"})
+			return
+		}
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		holdReservedTables(ctx, time.Now())
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// SeatReservation seats a booked party at its table, or at the table given
+// in the body, and opens its order.
+func SeatReservation() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Table_id *string `json:"table_id"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		reservationId := c.Param("reservation_id")
+		var reservation models.Reservation
+		if err := reservationCollection.FindOne(ctx, bson.M{"reservation_id": reservationId}).Decode(&reservation); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reservation was not found"})
+			return
+		}
+		if *reservation.Reservation_status != models.ReservationStatusBooked {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a %s reservation cannot be seated", *reservation.Reservation_status)})
+			return
+		}
+
+		tableId := reservation.Table_id
+		if body.Table_id != nil {
+			tableId = body.Table_id
+		}
+		if tableId == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the reservation has no table, give one"})
+			return
+		}
+
+		until := time.Now().Add(time.Duration(reservation.Duration_minutes) * time.Minute)
+		if reservation.Ends_at != nil && reservation.Ends_at.After(until) {
+			until = *reservation.Ends_at
+		}
+		_, err := seatParty(ctx, *tableId, *reservation.Party_size, c.GetString("user_id"), until, reservationId, func(sessCtx mongo.SessionContext, orderId string) error {
+			now := time.Now()
+			Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+			// Conditional on the booking still waiting, so it is only seated once.
+			filter := bson.M{"reservation_id": reservationId, "reservation_status": models.ReservationStatusBooked}
+			opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+			err := reservationCollection.FindOneAndUpdate(sessCtx, filter, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "reservation_status", Value: models.ReservationStatusSeated},
+					{Key: "table_id", Value: tableId},
+					{Key: "order_id", Value: orderId},
+					{Key: "seated_at", Value: now},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+			}, opts).Decode(&reservation)
+			if err == mongo.ErrNoDocuments {
+				return &tableError{Status: http.StatusConflict, Code: "RESERVATION_CHANGED", Message: "the reservation was seated or cancelled meanwhile"}
+			}
+			return err
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+// bookTable fills in when a reservation ends and makes sure its table can
+// take it: the table given must be large enough and free for the whole
+// booking; without one, the smallest table that is gets picked. It must run
+// in the transaction that saves the reservation: it takes the booking lock
+// of the table it settles on, so concurrent bookings of that table conflict
+// and are retried against each other's result.
+func bookTable(ctx context.Context, reservation *models.Reservation) error {
+	if reservation.Duration_minutes == 0 {
+		reservation.Duration_minutes = models.DefaultReservationMinutes
+	}
+	endsAt := reservation.Reserved_for.Add(time.Duration(reservation.Duration_minutes) * time.Minute)
+	reservation.Ends_at = &endsAt
+
+	tables, err := availableTables(ctx, *reservation.Party_size, *reservation.Reserved_for, endsAt, reservation.Reservation_id)
+	if err != nil {
+		return err
+	}
+
+	if reservation.Table_id == nil {
+		if len(tables) == 0 {
+			return &tableError{Status: http.StatusConflict, Code: "NO_TABLE_AVAILABLE", Message: fmt.Sprintf("no table for %d is free at that time", *reservation.Party_size)}
+		}
+		reservation.Table_id = &tables[0].Table_id
+		return lockTableBookings(ctx, tables[0].Table_id)
+	}
+
+	for _, table := range tables {
+		if table.Table_id == *reservation.Table_id {
+			return lockTableBookings(ctx, table.Table_id)
+		}
+	}
+	var table models.Table
+	if err = tableCollection.FindOne(ctx, bson.M{"table_id": reservation.Table_id}).Decode(&table); err != nil {
+		return &tableError{Status: http.StatusNotFound, Code: "TABLE_NOT_FOUND", Message: "table was not found"}
+	}
+	if table.Seat_capacity == nil || *table.Seat_capacity < *reservation.Party_size {
+		return &tableError{Status: http.StatusConflict, Code: "TABLE_TOO_SMALL", Message: fmt.Sprintf("table %d is too small for %d", *table.Table_number, *reservation.Party_size)}
+	}
+	return &tableError{Status: http.StatusConflict, Code: "RESERVATION_CONFLICT", Message: fmt.Sprintf("table %d is already booked at that time", *table.Table_number)}
+}
+
+// availableTables returns the tables seating at least partySize that no
+// other live booking holds between start and end, smallest first. The
+// reservation being changed, if any, is left out of the conflicts.
+func availableTables(ctx context.Context, partySize int, start time.Time, end time.Time, reservationId string) ([]models.Table, error) {
+	conflicts := bson.M{
+		"reservation_status": bson.M{"$in": bson.A{models.ReservationStatusBooked, models.ReservationStatusSeated}},
+		"reserved_for":       bson.M{"$lt": end},
+		"ends_at":            bson.M{"$gt": start},
+	}
+	if reservationId != "" {
+		conflicts["reservation_id"] = bson.M{"$ne": reservationId}
+	}
+	booked, err := reservationCollection.Distinct(ctx, "table_id", conflicts)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"seat_capacity": bson.M{"$gte": partySize},
+		"table_id":      bson.M{"$nin": booked},
+	}
+	result, err := tableCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	tables := []models.Table{}
+	if err = result.All(ctx, &tables); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		return *tables[i].Seat_capacity < *tables[j].Seat_capacity
+	})
+	return tables, nil
+}
+
+// reservationHoldMinutes is how long before a booking its table is held as
+// RESERVED on the floor plan.
+const reservationHoldMinutes = 30
+
+// lockTableBookings writes the booking lock of a table. Every transaction
+// that books or seats a table writes it first, so two of them working on the
+// same table conflict instead of both seeing it free.
+func lockTableBookings(ctx context.Context, tableId string) error {
+	_, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "booking_lock", Value: 1}}},
+	})
+	return err
+}
+
+// nextBooking returns the first booking, other than reservationId, that
+// holds a table between start and end, or nil if there is none.
+func nextBooking(ctx context.Context, tableId string, start time.Time, end time.Time, reservationId string) (*models.Reservation, error) {
+	filter := bson.M{
+		"table_id":           tableId,
+		"reservation_status": models.ReservationStatusBooked,
+		"reserved_for":       bson.M{"$lt": end},
+		"ends_at":            bson.M{"$gt": start},
+	}
+	if reservationId != "" {
+		filter["reservation_id"] = bson.M{"$ne": reservationId}
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "reserved_for", Value: 1}})
+	var reservation models.Reservation
+	err := reservationCollection.FindOne(ctx, filter, opts).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// upcomingBookings returns the live bookings of the given tables that have
+// not ended by now, by table and in time order.
+func upcomingBookings(ctx context.Context, tableIds []string, now time.Time) (map[string][]models.Reservation, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "reserved_for", Value: 1}})
+	result, err := reservationCollection.Find(ctx, bson.M{
+		"table_id":           bson.M{"$in": tableIds},
+		"reservation_status": models.ReservationStatusBooked,
+		"ends_at":            bson.M{"$gt": now},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var reservations []models.Reservation
+	if err = result.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+
+	byTable := map[string][]models.Reservation{}
+	for _, reservation := range reservations {
+		byTable[*reservation.Table_id] = append(byTable[*reservation.Table_id], reservation)
+	}
+	return byTable, nil
+}
+
+// holdReservedTables marks free tables whose booking starts within
+// reservationHoldMinutes as RESERVED, and frees the tables it held for
+// bookings that have since been cancelled, moved or have lapsed. Tables set
+// to RESERVED by hand are left alone.
+func holdReservedTables(ctx context.Context, now time.Time) {
+	opts := options.Find().SetSort(bson.D{{Key: "reserved_for", Value: -1}})
+	result, err := reservationCollection.Find(ctx, bson.M{
+		"table_id":           bson.M{"$ne": nil},
+		"reservation_status": models.ReservationStatusBooked,
+		"reserved_for":       bson.M{"$lt": now.Add(reservationHoldMinutes * time.Minute)},
+		"ends_at":            bson.M{"$gt": now},
+	}, opts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var reservations []models.Reservation
+	if err = result.All(ctx, &reservations); err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Later bookings come first, so the earliest one of a table wins.
+	heldFor := map[string]string{}
+	for _, reservation := range reservations {
+		heldFor[*reservation.Table_id] = reservation.Reservation_id
+	}
+
+	Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	held := []string{}
+	for tableId, reservationId := range heldFor {
+		held = append(held, reservationId)
+		_, err = tableCollection.UpdateOne(ctx, bson.M{
+			"table_id": tableId,
+			"$or": bson.A{
+				bson.M{"table_status": bson.M{"$in": bson.A{nil, models.TableStatusFree}}},
+				bson.M{"table_status": models.TableStatusReserved, "held_reservation_id": bson.M{"$ne": nil}},
+			},
+		}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "table_status", Value: models.TableStatusReserved},
+				{Key: "held_reservation_id", Value: reservationId},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	_, err = tableCollection.UpdateMany(ctx, bson.M{
+		"table_status":        models.TableStatusReserved,
+		"held_reservation_id": bson.M{"$ne": nil, "$nin": held},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "table_status", Value: models.TableStatusFree},
+			{Key: "held_reservation_id", Value: nil},
+			{Key: "updated_at", Value: Updated_at},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"restaurant-management/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertTestTable stores a free table for capacity guests and removes it and
+// the orders opened at it when the test ends.
+func insertTestTable(t *testing.T, number int, capacity int) models.Table {
+	t.Helper()
+	free := models.TableStatusFree
+	guests := 0
+	table := models.Table{ID: primitive.NewObjectID(), Number_of_guests: &guests, Table_number: &number, Table_status: &free, Seat_capacity: &capacity}
+	table.Table_id = table.ID.Hex()
+	if _, err := tableCollection.InsertOne(context.Background(), table); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		tableCollection.DeleteOne(context.Background(), bson.M{"table_id": table.Table_id})
+		orderCollection.DeleteMany(context.Background(), bson.M{"table_id": table.Table_id})
+	})
+	return table
+}
+
+func TestSeatReservationOnce(t *testing.T) {
+	requireTransactions(t)
+
+	tables := []models.Table{insertTestTable(t, 9001, 4), insertTestTable(t, 9002, 4)}
+
+	status := models.ReservationStatusBooked
+	partySize := 2
+	reservedFor := time.Now().Add(10 * time.Minute)
+	name, phone := "Test Party", "+10000000000"
+	reservation := models.Reservation{
+		ID:                 primitive.NewObjectID(),
+		Party_size:         &partySize,
+		Reserved_for:       &reservedFor,
+		Duration_minutes:   60,
+		Contact_name:       &name,
+		Contact_phone:      &phone,
+		Table_id:           &tables[0].Table_id,
+		Reservation_status: &status,
+	}
+	reservation.Reservation_id = reservation.ID.Hex()
+	if _, err := reservationCollection.InsertOne(context.Background(), reservation); err != nil {
+		t.Fatal(err)
+	}
+	defer reservationCollection.DeleteOne(context.Background(), bson.M{"reservation_id": reservation.Reservation_id})
+
+	// Two hosts seat the booking at the same time, at different tables.
+	path := "/reservations/" + reservation.Reservation_id + "/seat"
+	codes := make([]int, len(tables))
+	var wg sync.WaitGroup
+	for i := range tables {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := performAs(models.RoleWaiter, "/reservations/:reservation_id/seat", path, SeatReservation(), gin.H{"table_id": tables[i].Table_id})
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	seated := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			seated++
+		} else if code != http.StatusConflict {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if seated != 1 {
+		t.Fatalf("the booking was seated %d times: %v", seated, codes)
+	}
+
+	var stored models.Reservation
+	if err := reservationCollection.FindOne(context.Background(), bson.M{"reservation_id": reservation.Reservation_id}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Order_id == nil {
+		t.Fatal("the seated reservation has no order")
+	}
+
+	for _, table := range tables {
+		var found models.Table
+		if err := tableCollection.FindOne(context.Background(), bson.M{"table_id": table.Table_id}).Decode(&found); err != nil {
+			t.Fatal(err)
+		}
+		orders, err := orderCollection.CountDocuments(context.Background(), bson.M{"table_id": table.Table_id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		atThisTable := *stored.Table_id == table.Table_id
+		if (*found.Table_status == models.TableStatusSeated) != atThisTable || (orders == 1) != atThisTable {
+			t.Errorf("table %d is %s with %d orders, the party sat at this table: %v", *table.Table_number, *found.Table_status, orders, atThisTable)
+		}
+		if atThisTable {
+			var order models.Order
+			if err = orderCollection.FindOne(context.Background(), bson.M{"table_id": table.Table_id}).Decode(&order); err != nil || order.Order_id != *stored.Order_id {
+				t.Errorf("the reservation points at order %s, the table has %+v (%v)", *stored.Order_id, order, err)
+			}
+		}
+	}
+}
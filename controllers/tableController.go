@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"restaurant-management/database"
@@ -12,7 +14,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/x/mongo/driver/mongocrypt/options"
 )
 
 var tableCollection *mongo.Collection = database.OpenCollection(database.Client, "table")
@@ -21,9 +22,11 @@ func GetTables() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		holdReservedTables(ctx, time.Now())
 
 		result, err := tableCollection.Find(context.TODO(), bson.M{})
-		defer cancel()
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while feteching tables"})
@@ -42,14 +45,15 @@ func GetTable() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		tableId := c.Param("table_id")
 
 		var table models.Table
-		err := orderCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
-		defer cancel()
+		err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the table"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
 		}
 		c.JSON(http.StatusOK, table)
 
@@ -61,6 +65,7 @@ func CreateTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var table models.Table
 		if err := c.BindJSON(&table); err != nil {
@@ -79,6 +84,10 @@ func CreateTable() gin.HandlerFunc {
 
 		table.ID = primitive.NewObjectID()
 		table.Table_id = table.ID.Hex()
+		if table.Table_status == nil {
+			status := models.TableStatusFree
+			table.Table_status = &status
+		}
 
 		result, resultErr := tableCollection.InsertOne(ctx, table)
 
@@ -86,7 +95,6 @@ func CreateTable() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Create Table Failed"})
 			return
 		}
-		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
 }
@@ -96,38 +104,378 @@ func UpdateTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var table models.Table
 		if err := c.BindJSON(&table); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			return
+		}
+		if table.Number_of_guests != nil && *table.Number_of_guests < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "number_of_guests cannot be negative"})
+			return
+		}
+		if table.Seat_capacity != nil && *table.Seat_capacity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seat_capacity must be at least 1"})
+			return
 		}
 		tableId := c.Param("table_id")
 
 		var updateObj primitive.D
 
 		if table.Number_of_guests != nil {
-			updateObj = append(updateObj, bson.E{Key: "number_of_guest", Value: table.Number_of_guests})
+			updateObj = append(updateObj, bson.E{Key: "number_of_guests", Value: table.Number_of_guests})
 		}
 		if table.Table_number != nil {
 			updateObj = append(updateObj, bson.E{Key: "table_number", Value: table.Table_number})
 		}
+		if table.Section != nil {
+			updateObj = append(updateObj, bson.E{Key: "section", Value: table.Section})
+		}
+		if table.Seat_capacity != nil {
+			updateObj = append(updateObj, bson.E{Key: "seat_capacity", Value: table.Seat_capacity})
+		}
+		if table.Pos_x != nil {
+			updateObj = append(updateObj, bson.E{Key: "pos_x", Value: table.Pos_x})
+		}
+		if table.Pos_y != nil {
+			updateObj = append(updateObj, bson.E{Key: "pos_y", Value: table.Pos_y})
+		}
 		table.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: table.Updated_at})
-		upsert := true
 		filter := bson.M{"table_id": tableId}
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
-		}
 		result, err := tableCollection.UpdateOne(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
-		}, &opt)
+		})
 
 		if err != nil {
 			msg := "Table update failed"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
-		defer cancel()
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
+		}
 		c.JSON(http.StatusOK, result)
 
 	}
 }
+
+// UpdateTableStatus lets floor staff set the status of a table by hand, e.g.
+// to seat walk-ins or mark a table clean again. Seating a party starts the
+// seating clock, and is refused when the table is booked within a turn;
+// freeing the table stops it and ends its QR code session.
+func UpdateTableStatus() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Status           *string `json:"status" validate:"required,eq=FREE|eq=SEATED|eq=ORDERED|eq=BILLED|eq=NEEDS_CLEANING|eq=RESERVED"`
+			Number_of_guests *int    `json:"number_of_guests" validate:"omitempty,min=0"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		tableId := c.Param("table_id")
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
+		}
+
+		if body.Number_of_guests != nil {
+			Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			_, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "number_of_guests", Value: body.Number_of_guests},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Table update failed"})
+				return
+			}
+		}
+		// Seating a walk-in at a free table must leave it a whole turn before
+		// the table's next booking.
+		if *body.Status == models.TableStatusSeated && (table.Table_status == nil || *table.Table_status == models.TableStatusFree || *table.Table_status == models.TableStatusReserved) {
+			now := time.Now()
+			err := withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+				if err := lockTableBookings(sessCtx, tableId); err != nil {
+					return err
+				}
+				booking, err := nextBooking(sessCtx, tableId, now, now.Add(averageTurnMinutes*time.Minute), "")
+				if err != nil {
+					return err
+				}
+				if booking != nil {
+					return &tableError{Status: http.StatusConflict, Code: "TABLE_BOOKED", Message: fmt.Sprintf("table %d is booked from %s", *table.Table_number, booking.Reserved_for.In(restaurantLocation).Format("15:04"))}
+				}
+				return setTableStatus(sessCtx, tableId, *body.Status)
+			})
+			if err != nil {
+				respondTableError(c, err)
+				return
+			}
+		} else if err := setTableStatus(ctx, tableId, *body.Status); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Table update failed"})
+			return
+		}
+
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the table"})
+			return
+		}
+		c.JSON(http.StatusOK, table)
+	}
+}
+
+// floorTable is a table as shown on the floor plan, with its ongoing order
+// and how long the party has been seated.
+type floorTable struct {
+	models.Table   `bson:",inline"`
+	Open_order     *models.Order `json:"open_order" bson:"open_order"`
+	Seated_seconds *int64        `json:"seated_seconds" bson:"-"`
+}
+
+// GetFloor returns every table for the floor plan, optionally of one section,
+// joined with its ongoing order and elapsed seating time.
+func GetFloor() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		match := bson.D{}
+		if section := c.Query("section"); section != "" {
+			match = append(match, bson.E{Key: "section", Value: section})
+		}
+
+		holdReservedTables(ctx, time.Now())
+
+		matchStage := bson.D{{Key: "$match", Value: match}}
+		lookupOrderStage := bson.D{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "order"},
+			{Key: "let", Value: bson.D{{Key: "table_id", Value: "$table_id"}}},
+			{Key: "pipeline", Value: mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{{Key: "$and", Value: bson.A{
+					bson.D{{Key: "$eq", Value: bson.A{"$table_id", "$$table_id"}}},
+					bson.D{{Key: "$in", Value: bson.A{
+						bson.D{{Key: "$ifNull", Value: bson.A{"$order_status", models.OrderStatusOpen}}},
+						bson.A{models.OrderStatusOpen, models.OrderStatusFired, models.OrderStatusReady, models.OrderStatusServed, models.OrderStatusBilled},
+					}}},
+				}}}}}}},
+				{{Key: "$sort", Value: bson.D{{Key: "order_date", Value: -1}}}},
+				{{Key: "$limit", Value: 1}},
+			}},
+			{Key: "as", Value: "open_order"},
+		}}}
+		unwindOrderStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$open_order"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
+		sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "section", Value: 1}, {Key: "table_number", Value: 1}}}}
+
+		result, err := tableCollection.Aggregate(ctx, mongo.Pipeline{
+			matchStage,
+			lookupOrderStage,
+			unwindOrderStage,
+			sortStage,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the floor"})
+			return
+		}
+
+		tables := []floorTable{}
+		if err = result.All(ctx, &tables); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the floor"})
+			return
+		}
+
+		now := time.Now()
+		for i := range tables {
+			if tables[i].Seated_at != nil {
+				seconds := int64(now.Sub(*tables[i].Seated_at).Seconds())
+				tables[i].Seated_seconds = &seconds
+			}
+		}
+
+		c.JSON(http.StatusOK, tables)
+	}
+}
+
+// setTableStatus moves a table to a status. Seating a party, or taking its
+// first order, starts the seating clock; freeing the table stops it and ends
+// its QR code session.
+func setTableStatus(ctx context.Context, tableId string, status string) error {
+	now := time.Now()
+	Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+
+	// A status set by hand or by an order replaces any hold for a booking.
+	updateObj := primitive.D{
+		{Key: "table_status", Value: status},
+		{Key: "held_reservation_id", Value: nil},
+		{Key: "updated_at", Value: Updated_at},
+	}
+	switch status {
+	case models.TableStatusFree, models.TableStatusReserved:
+		updateObj = append(updateObj, bson.E{Key: "seated_at", Value: nil})
+		updateObj = append(updateObj, bson.E{Key: "number_of_guests", Value: 0})
+		updateObj = append(updateObj, bson.E{Key: "session_id", Value: nil})
+		updateObj = append(updateObj, bson.E{Key: "session_expires_at", Value: nil})
+	case models.TableStatusNeedsCleaning:
+		updateObj = append(updateObj, bson.E{Key: "seated_at", Value: nil})
+		updateObj = append(updateObj, bson.E{Key: "session_id", Value: nil})
+		updateObj = append(updateObj, bson.E{Key: "session_expires_at", Value: nil})
+	}
+
+	_, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId}, bson.D{
+		{Key: "$set", Value: updateObj},
+	})
+	if err != nil {
+		return err
+	}
+
+	if status == models.TableStatusSeated || status == models.TableStatusOrdered {
+		_, err = tableCollection.UpdateOne(ctx, bson.M{"table_id": tableId, "seated_at": nil}, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "seated_at", Value: now}}},
+		})
+	}
+	return err
+}
+
+// syncTableStatus follows the status of an order on its table.
+func syncTableStatus(ctx context.Context, order models.Order) {
+	if order.Table_id == nil {
+		return
+	}
+
+	status := ""
+	switch orderStatus(order) {
+	case models.OrderStatusOpen:
+		status = models.TableStatusSeated
+	case models.OrderStatusFired:
+		status = models.TableStatusOrdered
+	case models.OrderStatusBilled:
+		status = models.TableStatusBilled
+	case models.OrderStatusClosed:
+		status = models.TableStatusNeedsCleaning
+	case models.OrderStatusCancelled, models.OrderStatusVoid:
+		// The party is still seated, whatever else it ordered.
+		status = models.TableStatusSeated
+		if other, err := ongoingTableOrder(ctx, *order.Table_id); err == nil && orderIsInKitchen(other) {
+			status = models.TableStatusOrdered
+		}
+	default:
+		return
+	}
+
+	if err := setTableStatus(ctx, *order.Table_id, status); err != nil {
+		log.Println(err)
+	}
+}
+
+// tableError rejects seating, moving or merging parties with a status and a
+// machine readable code.
+type tableError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *tableError) Error() string {
+	return e.Message
+}
+
+func respondTableError(c *gin.Context, err error) {
+	var tableErr *tableError
+	if errors.As(err, &tableErr) {
+		c.JSON(tableErr.Status, gin.H{"error": tableErr.Message, "code": tableErr.Code})
+		return
+	}
+	log.Println(err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while updating the table"})
+}
+
+// seatParty sits a party at a free or reserved table and opens its order.
+// The table is taken with a conditional update, so two hosts cannot seat
+// parties at the same table, and only if no other booking holds it before
+// until; reservationId is the booking being seated, if any. Checking the
+// bookings, taking the table, opening the order and seated, which records
+// where the party sat, happen in one transaction that takes the table's
+// booking lock, so a booking cannot slip in between and a failure leaves
+// nothing half done.
+func seatParty(ctx context.Context, tableId string, partySize int, userId string, until time.Time, reservationId string, seated func(sessCtx mongo.SessionContext, orderId string) error) (string, error) {
+	var table models.Table
+	err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
+	if err == mongo.ErrNoDocuments {
+		return "", &tableError{Status: http.StatusNotFound, Code: "TABLE_NOT_FOUND", Message: "table was not found"}
+	}
+	if err != nil {
+		return "", err
+	}
+	if table.Seat_capacity != nil && partySize > *table.Seat_capacity {
+		return "", &tableError{Status: http.StatusConflict, Code: "TABLE_TOO_SMALL", Message: fmt.Sprintf("table %d seats %d", *table.Table_number, *table.Seat_capacity)}
+	}
+
+	now := time.Now()
+	Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+
+	var order models.Order
+	order.Table_id = &tableId
+	order.Order_Date = Updated_at
+	order.Created_at = Updated_at
+	order.Updated_at = Updated_at
+	openOrder(&order, userId)
+	order.ID = primitive.NewObjectID()
+	order.Order_id = order.ID.Hex()
+
+	err = withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := lockTableBookings(sessCtx, tableId); err != nil {
+			return err
+		}
+		booking, err := nextBooking(sessCtx, tableId, now, until, reservationId)
+		if err != nil {
+			return err
+		}
+		if booking != nil {
+			return &tableError{Status: http.StatusConflict, Code: "TABLE_BOOKED", Message: fmt.Sprintf("table %d is booked from %s", *table.Table_number, booking.Reserved_for.In(restaurantLocation).Format("15:04"))}
+		}
+
+		filter := bson.M{
+			"table_id":     tableId,
+			"table_status": bson.M{"$in": bson.A{nil, models.TableStatusFree, models.TableStatusReserved}},
+		}
+		result, err := tableCollection.UpdateOne(sessCtx, filter, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "table_status", Value: models.TableStatusSeated},
+				{Key: "number_of_guests", Value: partySize},
+				{Key: "seated_at", Value: now},
+				{Key: "held_reservation_id", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return &tableError{Status: http.StatusConflict, Code: "TABLE_OCCUPIED", Message: fmt.Sprintf("table %d is not free", *table.Table_number)}
+		}
+
+		if _, err = orderCollection.InsertOne(sessCtx, order); err != nil {
+			return err
+		}
+		return seated(sessCtx, order.Order_id)
+	})
+	if err != nil {
+		return "", err
+	}
+	return order.Order_id, nil
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var taxRateCollection *mongo.Collection = database.OpenCollection(database.Client, "taxRate")
+
+// GetTaxRates lists the tax rates, optionally those of one ?category=.
+func GetTaxRates() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if category := c.Query("category"); category != "" {
+			filter["category"] = category
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "category", Value: 1}, {Key: "name", Value: 1}})
+		result, err := taxRateCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing tax rates"})
+			return
+		}
+
+		allTaxRates := []bson.M{}
+		if err = result.All(ctx, &allTaxRates); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing tax rates"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allTaxRates)
+	}
+}
+
+func CreateTaxRate() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var taxRate models.TaxRate
+		if err := c.BindJSON(&taxRate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(taxRate)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if taxRate.Category != nil && *taxRate.Category == "" {
+			taxRate.Category = nil
+		}
+
+		taxRate.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		taxRate.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		taxRate.ID = primitive.NewObjectID()
+		taxRate.Tax_rate_id = taxRate.ID.Hex()
+
+		if _, insertErr := taxRateCollection.InsertOne(ctx, taxRate); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Tax rate was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, taxRate)
+	}
+}
+
+// UpdateTaxRate changes a tax rate. Invoices already created keep the rates
+// they were computed with.
+func UpdateTaxRate() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var taxRate models.TaxRate
+		if err := c.BindJSON(&taxRate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if taxRate.Name != nil {
+			if validationErr := validate.Var(*taxRate.Name, "min=1,max=50"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "name", Value: taxRate.Name})
+		}
+		if taxRate.Rate != nil {
+			if validationErr := validate.Var(*taxRate.Rate, "min=0,max=100"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "rate", Value: taxRate.Rate})
+		}
+		if taxRate.Category != nil {
+			// An empty category turns the rate into the default one.
+			if *taxRate.Category == "" {
+				updateObj = append(updateObj, bson.E{Key: "category", Value: nil})
+			} else {
+				updateObj = append(updateObj, bson.E{Key: "category", Value: taxRate.Category})
+			}
+		}
+
+		taxRate.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: taxRate.Updated_at})
+
+		var updated models.TaxRate
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := taxRateCollection.FindOneAndUpdate(ctx, bson.M{"tax_rate_id": c.Param("tax_rate_id")}, bson.D{
+			{Key: "$set", Value: updateObj},
+		}, opts).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tax rate was not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Tax rate update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+func DeleteTaxRate() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := taxRateCollection.DeleteOne(ctx, bson.M{"tax_rate_id": c.Param("tax_rate_id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Tax rate was not deleted"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tax rate was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var terminalCollection *mongo.Collection = database.OpenCollection(database.Client, "terminal")
+
+func GetTerminals() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetProjection(bson.M{"terminal_key": 0})
+		result, err := terminalCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing terminals"})
+			return
+		}
+
+		var allTerminals []bson.M
+		if err = result.All(ctx, &allTerminals); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing terminals"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allTerminals)
+	}
+}
+
+// CreateTerminal registers a POS terminal. The terminal key is only returned
+// here; the device has to store it and present it on every PIN login, and in
+// the X-Terminal-Key header of every request made with a terminal token.
+func CreateTerminal() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var terminal models.Terminal
+		if err := c.BindJSON(&terminal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(terminal)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		key, err := randomToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating the terminal key"})
+			return
+		}
+		hashedKey, err := HashPassword(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while hashing the terminal key"})
+			return
+		}
+
+		active := true
+		terminal.Active = &active
+		terminal.Terminal_key = &hashedKey
+		terminal.Created_by = c.GetString("user_id")
+		terminal.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		terminal.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		terminal.ID = primitive.NewObjectID()
+		terminal.Terminal_id = terminal.ID.Hex()
+
+		_, insertErr := terminalCollection.InsertOne(ctx, terminal)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Terminal was not registered"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"terminal_id": terminal.Terminal_id, "name": terminal.Name, "terminal_key": key})
+	}
+}
+
+func UpdateTerminal() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var terminal models.Terminal
+		if err := c.BindJSON(&terminal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		terminalId := c.Param("terminal_id")
+
+		var updateObj primitive.D
+
+		if terminal.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: terminal.Name})
+		}
+		if terminal.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: terminal.Active})
+		}
+
+		terminal.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: terminal.Updated_at})
+
+		result, err := terminalCollection.UpdateOne(ctx, bson.M{"terminal_id": terminalId}, bson.D{
+			{Key: "$set", Value: updateObj},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Terminal update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "terminal was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// randomToken returns 32 random bytes, hex encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
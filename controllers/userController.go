@@ -1,47 +1,666 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
 	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/middleware"
+	"restaurant-management/models"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
+// userProjection keeps credentials out of every user listing.
+var userProjection = bson.D{
+	{Key: "password", Value: 0},
+	{Key: "token", Value: 0},
+	{Key: "refresh_token", Value: 0},
+	{Key: "pin", Value: 0},
+}
+
 func GetUsers() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 10
+		}
+
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		startIndex := (page - 1) * recordPerPage
+		if start, err := strconv.Atoi(c.Query("startIndex")); err == nil && start >= 0 {
+			startIndex = start
+		}
+
+		matchStage := bson.D{{Key: "$match", Value: bson.D{{}}}}
+		sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}}}}
+		unsetStage := bson.D{{Key: "$project", Value: userProjection}}
+		groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "null"}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
+		projectStage := bson.D{
+			{
+				Key: "$project",
+				Value: bson.D{
+					{Key: "_id", Value: 0},
+					{Key: "total_count", Value: 1},
+					{Key: "user_items", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
+				},
+			},
+		}
+
+		result, err := userCollection.Aggregate(ctx, mongo.Pipeline{
+			matchStage,
+			sortStage,
+			unsetStage,
+			groupStage,
+			projectStage,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing users"})
+			return
+		}
 
+		var allUsers []bson.M
+		if err = result.All(ctx, &allUsers); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing users"})
+			return
+		}
+
+		if len(allUsers) == 0 {
+			c.JSON(http.StatusOK, gin.H{"total_count": 0, "user_items": []bson.M{}})
+			return
+		}
+		c.JSON(http.StatusOK, allUsers[0])
 	}
 }
 
 func GetUser() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
 
+		var user bson.M
+		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}, options.FindOne().SetProjection(userProjection)).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the user"})
+			return
+		}
+		c.JSON(http.StatusOK, user)
 	}
 }
 
+// SignUp is only open while the system has no users: the first account
+// becomes the owner, every later account is created by management via CreateUser.
 func SignUp() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, err := userCollection.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for users"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": errSignUpClosed.Error()})
+			return
+		}
 
+		role := models.RoleOwner
+		user.Role = &role
+
+		registerUser(ctx, c, user, insertFirstUser)
 	}
 }
 
+var setupCollection *mongo.Collection = database.OpenCollection(database.Client, "setup")
+
+var errSignUpClosed = errors.New("sign-up is closed, ask a manager to create your account")
+
+// insertFirstUser stores user only if there are no users yet. The check and
+// the insert run in a transaction that first writes the sign-up lock, so of
+// concurrent sign-ups on a fresh install only one gets in.
+func insertFirstUser(ctx context.Context, user models.User) error {
+	return withTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		opts := options.Update().SetUpsert(true)
+		_, err := setupCollection.UpdateOne(sessCtx, bson.M{"_id": "signup_lock"}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}, opts)
+		if err != nil {
+			return err
+		}
+		count, err := userCollection.CountDocuments(sessCtx, bson.M{})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errSignUpClosed
+		}
+		_, err = userCollection.InsertOne(sessCtx, user)
+		return err
+	})
+}
+
+func insertUser(ctx context.Context, user models.User) error {
+	_, err := userCollection.InsertOne(ctx, user)
+	return err
+}
+
+func CreateUser() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.Role == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"})
+			return
+		}
+		if !canAssignRole(c, *user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "your role is not allowed to create a user with this role"})
+			return
+		}
+
+		registerUser(ctx, c, user, insertUser)
+	}
+}
+
+func UpdateUserRole() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+
+		var body struct {
+			Role *string `json:"role" validate:"required,eq=OWNER|eq=MANAGER|eq=WAITER|eq=CASHIER|eq=KITCHEN"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+			return
+		}
+
+		if userId == c.GetString("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you cannot change your own role"})
+			return
+		}
+		if !canAssignRole(c, *body.Role) || (foundUser.Role != nil && !canAssignRole(c, *foundUser.Role)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "your role is not allowed to change this user's role"})
+			return
+		}
+
+		// Tokens carry the role they were issued with: bumping the token
+		// version and clearing the refresh token signs the user out, so the
+		// new role applies from their next sign in. The update is conditional
+		// on the role checked above.
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userId, "role": foundUser.Role}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "token_version", Value: 1}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "role", Value: body.Role},
+				{Key: "token", Value: nil},
+				{Key: "refresh_token", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user role update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the user's role was changed by someone else, reload and try again"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// canAssignRole reports whether the authenticated user may hand out role.
+// Owners may assign any role, managers only the floor and kitchen roles.
+func canAssignRole(c *gin.Context, role string) bool {
+	if middleware.HasRole(c, models.RoleOwner) {
+		return true
+	}
+	if middleware.HasRole(c, models.RoleManager) {
+		return role != models.RoleOwner && role != models.RoleManager
+	}
+	return false
+}
+
+// registerUser validates, hashes and stores a new user with insert and
+// writes the response. The email and phone checks give a friendly answer;
+// the unique indexes on both refuse what slips past them concurrently.
+func registerUser(ctx context.Context, c *gin.Context, user models.User, insert func(ctx context.Context, user models.User) error) {
+	validationErr := validate.Struct(user)
+	if validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the email"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+		return
+	}
+
+	count, err = userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the phone number"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this phone number already exists"})
+		return
+	}
+
+	password, err := HashPassword(*user.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while hashing the password"})
+		return
+	}
+	user.Password = &password
+	user.Pin = nil
+	user.Pin_attempts = 0
+	user.Pin_locked_until = nil
+	user.Email_verified = false
+	user.Token_version = 0
+
+	user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.User_id = user.ID.Hex()
+
+	token, refreshToken, err := helper.GenerateTokens(*user.Email, user.User_id, *user.Role, user.Token_version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating tokens"})
+		return
+	}
+	user.Token = &token
+	user.Refresh_Token = &refreshToken
+
+	insertErr := insert(ctx, user)
+	if errors.Is(insertErr, errSignUpClosed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": insertErr.Error()})
+		return
+	}
+	if mongo.IsDuplicateKeyError(insertErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": "this email or phone number already exists"})
+		return
+	}
+	if insertErr != nil {
+		msg := fmt.Sprintf("User item was not created")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		return
+	}
+
+	if err = sendUserToken(ctx, user, models.TokenPurposeEmailVerification); err != nil {
+		log.Println(err)
+	}
+
+	c.JSON(http.StatusOK, &mongo.InsertOneResult{InsertedID: user.ID})
+}
+
 func Login() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		var foundUser models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "email or password is incorrect"})
+			return
+		}
+
+		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		if !passwordIsValid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		var role string
+		if foundUser.Role != nil {
+			role = *foundUser.Role
+		}
+
+		token, refreshToken, err := helper.GenerateTokens(*foundUser.Email, foundUser.User_id, role, foundUser.Token_version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating tokens"})
+			return
+		}
+
+		if err = helper.UpdateAllToken(token, refreshToken, foundUser.User_id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while saving tokens"})
+			return
+		}
+
+		foundUser.Password = nil
+		foundUser.Pin = nil
+		foundUser.Token = &token
+		foundUser.Refresh_Token = &refreshToken
+
+		c.JSON(http.StatusOK, foundUser)
+	}
+}
+
+func RefreshToken() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var body struct {
+			Refresh_token string `json:"refresh_token" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		token, refreshToken, err := helper.RefreshTokens(body.Refresh_token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// Number of wrong PINs after which a user is locked out of PIN login, and for how long.
+const (
+	maxPinAttempts  = 5
+	pinLockDuration = 15 * time.Minute
+)
+
+func UpdateUserPin() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+		if userId != c.GetString("user_id") && !middleware.HasRole(c, models.RoleOwner, models.RoleManager) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only change your own PIN"})
+			return
+		}
+
+		var body struct {
+			Pin *string `json:"pin" validate:"required,numeric,min=4,max=6"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// Setting someone else's PIN lets you sign in as them, so it takes the
+		// same rights as changing their role. The role is part of the filter so
+		// a promotion in between is not overlooked.
+		filter := bson.M{"user_id": userId}
+		if userId != c.GetString("user_id") {
+			var foundUser models.User
+			err := userCollection.FindOne(ctx, filter).Decode(&foundUser)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+				return
+			}
+			if foundUser.Role != nil && !canAssignRole(c, *foundUser.Role) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "your role is not allowed to change this user's PIN"})
+				return
+			}
+			filter["role"] = foundUser.Role
+		}
+
+		pin, err := HashPassword(*body.Pin)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while hashing the PIN"})
+			return
+		}
+
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := userCollection.UpdateOne(ctx, filter, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "pin", Value: pin},
+				{Key: "pin_attempts", Value: 0},
+				{Key: "pin_locked_until", Value: nil},
+				{Key: "updated_at", Value: Updated_at},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "PIN update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "PIN updated"})
+	}
+}
+
+// PinLogin signs a user in on a registered terminal with their numeric PIN
+// and returns a short lived token bound to that terminal.
+func PinLogin() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Terminal_id  *string `json:"terminal_id" validate:"required"`
+			Terminal_key *string `json:"terminal_key" validate:"required"`
+			User_id      *string `json:"user_id" validate:"required"`
+			Pin          *string `json:"pin" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := helper.VerifyTerminal(ctx, *body.Terminal_id, *body.Terminal_key); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser, err := verifyUserPin(ctx, *body.User_id, *body.Pin)
+		if err != nil {
+			respondPinError(c, err)
+			return
+		}
+
+		var role string
+		if foundUser.Role != nil {
+			role = *foundUser.Role
+		}
+
+		token, expiresAt, err := helper.GenerateTerminalToken(*foundUser.Email, foundUser.User_id, role, foundUser.Token_version, *body.Terminal_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating tokens"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":       token,
+			"expires_at":  expiresAt,
+			"terminal_id": *body.Terminal_id,
+			"user_id":     foundUser.User_id,
+			"first_name":  foundUser.First_name,
+			"role":        role,
+		})
+	}
+}
+
+// errPinIncorrect is returned when a user or their PIN is not recognised.
+var errPinIncorrect = errors.New("user or PIN is incorrect")
 
+// pinLockedError is returned while a user is locked out after too many wrong
+// PINs.
+type pinLockedError struct {
+	Until time.Time
+}
+
+func (e *pinLockedError) Error() string {
+	return "too many wrong PINs, try again later"
+}
+
+func respondPinError(c *gin.Context, err error) {
+	var lockedErr *pinLockedError
+	if errors.As(err, &lockedErr) {
+		c.JSON(http.StatusLocked, gin.H{"error": lockedErr.Error(), "locked_until": lockedErr.Until})
+		return
 	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": errPinIncorrect.Error()})
 }
 
-func HashPassword(password string) string {
+// verifyUserPin checks the PIN of a user. Every wrong PIN counts towards
+// locking the user out for a while; a right one resets the count.
+func verifyUserPin(ctx context.Context, userId string, pin string) (models.User, error) {
+	var foundUser models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&foundUser)
+	if err != nil || foundUser.Pin == nil {
+		return foundUser, errPinIncorrect
+	}
+
+	now := time.Now()
+	if foundUser.Pin_locked_until != nil && foundUser.Pin_locked_until.After(now) {
+		return foundUser, &pinLockedError{Until: *foundUser.Pin_locked_until}
+	}
+
+	if pinIsValid, _ := VerifyPassword(pin, *foundUser.Pin); !pinIsValid {
+		var attempts struct {
+			Pin_attempts int `bson:"pin_attempts"`
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": foundUser.User_id}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "pin_attempts", Value: 1}}},
+		}, opts).Decode(&attempts)
+		if err != nil {
+			log.Println(err)
+		}
 
+		if attempts.Pin_attempts >= maxPinAttempts {
+			lockedUntil := now.Add(pinLockDuration)
+			_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "pin_attempts", Value: 0},
+					{Key: "pin_locked_until", Value: lockedUntil},
+				}},
+			})
+			if err != nil {
+				log.Println(err)
+			}
+			return foundUser, &pinLockedError{Until: lockedUntil}
+		}
+
+		return foundUser, errPinIncorrect
+	}
+
+	_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "pin_attempts", Value: 0},
+			{Key: "pin_locked_until", Value: nil},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return foundUser, nil
 }
 
-func VerifyPassword(userPassword string, providePassword string) (bool, string) {
+func HashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
 
+func VerifyPassword(providedPassword string, userPassword string) (bool, string) {
+	err := bcrypt.CompareHashAndPassword([]byte(userPassword), []byte(providedPassword))
+	if err != nil {
+		return false, "email or password is incorrect"
+	}
+	return true, ""
 }
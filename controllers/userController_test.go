@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurant-management/database"
+	helper "restaurant-management/helpers"
+	"restaurant-management/models"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUpdateUserRoleRevokesTokens(t *testing.T) {
+	requireDatabase(t)
+
+	email := primitive.NewObjectID().Hex() + "@role.test"
+	role := models.RoleManager
+	user := models.User{ID: primitive.NewObjectID(), Email: &email, Role: &role}
+	user.User_id = user.ID.Hex()
+	if _, err := userCollection.InsertOne(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	defer userCollection.DeleteOne(context.Background(), bson.M{"user_id": user.User_id})
+
+	accessToken, refreshToken, err := helper.GenerateTokens(email, user.User_id, role, user.Token_version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = helper.UpdateAllToken(accessToken, refreshToken, user.User_id); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := helper.ValidateToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/users/" + user.User_id + "/role"
+	w := performAs(models.RoleOwner, "/users/:user_id/role", path, UpdateUserRole(), gin.H{"role": models.RoleWaiter})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	if err = helper.ValidateTokenVersion(context.Background(), claims); !errors.Is(err, helper.ErrTokenRevoked) {
+		t.Errorf("manager token after the demotion: got %v, want %v", err, helper.ErrTokenRevoked)
+	}
+	if _, _, err = helper.RefreshTokens(refreshToken); !errors.Is(err, helper.ErrTokenInvalid) {
+		t.Errorf("refresh after the demotion: got %v, want %v", err, helper.ErrTokenInvalid)
+	}
+
+	var found models.User
+	if err = userCollection.FindOne(context.Background(), bson.M{"user_id": user.User_id}).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if *found.Role != models.RoleWaiter {
+		t.Errorf("role is %s, want %s", *found.Role, models.RoleWaiter)
+	}
+}
+
+func TestSignUpOnlyOneOwner(t *testing.T) {
+	requireTransactions(t)
+	ctx := context.Background()
+	if err := database.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := userCollection.CountDocuments(ctx, bson.M{}); err != nil || count > 0 {
+		t.Skipf("sign-up is only open on a database without users (%d users, %v)", count, err)
+	}
+	defer userCollection.DeleteMany(ctx, bson.M{"email": bson.M{"$regex": `@signup\.test$`}})
+
+	const signUps = 5
+	codes := make([]int, signUps)
+	var wg sync.WaitGroup
+	for i := 0; i < signUps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := performJSON(SignUp(), gin.H{
+				"first_name": "Owner",
+				"last_name":  "Number" + strconv.Itoa(i),
+				"email":      "owner" + strconv.Itoa(i) + "@signup.test",
+				"phone":      "+1000000000" + strconv.Itoa(i),
+				"password":   "owner-password",
+			})
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			created++
+		} else if code != http.StatusForbidden {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d owners signed up: %v", created, codes)
+	}
+	if count, err := userCollection.CountDocuments(ctx, bson.M{"role": models.RoleOwner, "email": bson.M{"$regex": `@signup\.test$`}}); err != nil || count != 1 {
+		t.Errorf("%d owners stored (%v)", count, err)
+	}
+}
+
+func TestUsersAreUnique(t *testing.T) {
+	requireDatabase(t)
+	ctx := context.Background()
+	if err := database.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sameEmail bool
+		samePhone bool
+		wantDup   bool
+	}{
+		{name: "same email", sameEmail: true, wantDup: true},
+		{name: "same phone", samePhone: true, wantDup: true},
+		{name: "different", wantDup: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newUser := func(email string, phone string) models.User {
+				user := models.User{ID: primitive.NewObjectID(), Email: &email, Phone: &phone}
+				user.User_id = user.ID.Hex()
+				return user
+			}
+			first := newUser(primitive.NewObjectID().Hex()+"@unique.test", primitive.NewObjectID().Hex())
+			second := newUser(primitive.NewObjectID().Hex()+"@unique.test", primitive.NewObjectID().Hex())
+			if tt.sameEmail {
+				second.Email = first.Email
+			}
+			if tt.samePhone {
+				second.Phone = first.Phone
+			}
+			defer userCollection.DeleteMany(ctx, bson.M{"user_id": bson.M{"$in": bson.A{first.User_id, second.User_id}}})
+
+			if err := insertUser(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			err := insertUser(ctx, second)
+			if mongo.IsDuplicateKeyError(err) != tt.wantDup {
+				t.Errorf("second insert: %v, want a duplicate key error: %v", err, tt.wantDup)
+			}
+		})
+	}
+}
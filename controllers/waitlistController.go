@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant-management/database"
+	"restaurant-management/models"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var waitlistCollection *mongo.Collection = database.OpenCollection(database.Client, "waitlist")
+
+// averageTurnMinutes is how long a party is expected to keep a table, used
+// to quote waits.
+const averageTurnMinutes = 60
+
+// GetWaitlist lists the parties still waiting, first come first.
+func GetWaitlist() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entries, err := waitingParties(ctx)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the waitlist"})
+			return
+		}
+
+		now := time.Now()
+		waitlist := []gin.H{}
+		for i, entry := range entries {
+			waitlist = append(waitlist, gin.H{
+				"position":       i + 1,
+				"waited_minutes": int(now.Sub(entry.Created_at).Minutes()),
+				"entry":          entry,
+			})
+		}
+		c.JSON(http.StatusOK, waitlist)
+	}
+}
+
+// AddToWaitlist puts a walk-in party on the waitlist and quotes its wait.
+func AddToWaitlist() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.WaitlistEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(entry); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		quote, err := quoteWait(ctx, *entry.Party_size, time.Now())
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+
+		status := models.WaitlistStatusWaiting
+		entry.Waitlist_status = &status
+		entry.Quoted_wait_minutes = quote
+		entry.Table_id = nil
+		entry.Order_id = nil
+		entry.Seated_at = nil
+		entry.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		entry.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		entry.ID = primitive.NewObjectID()
+		entry.Waitlist_entry_id = entry.ID.Hex()
+
+		if _, err = waitlistCollection.InsertOne(ctx, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the party was not added to the waitlist"})
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// UpdateWaitlistEntry changes a waiting party, or takes it off the list when
+// it leaves.
+func UpdateWaitlistEntry() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.WaitlistEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if entry.Waitlist_status != nil {
+			if *entry.Waitlist_status != models.WaitlistStatusLeft {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "waitlist_status can only be set to LEFT, seat the party to mark it SEATED"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "waitlist_status", Value: entry.Waitlist_status})
+		}
+		if entry.Party_size != nil {
+			if *entry.Party_size < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "party_size must be at least 1"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "party_size", Value: entry.Party_size})
+		}
+		if entry.Contact_name != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_name", Value: entry.Contact_name})
+		}
+		if entry.Contact_phone != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_phone", Value: entry.Contact_phone})
+		}
+		if entry.Notes != nil {
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: entry.Notes})
+		}
+
+		entry.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: entry.Updated_at})
+
+		var updated models.WaitlistEntry
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := waitlistCollection.FindOneAndUpdate(ctx,
+			bson.M{"waitlist_entry_id": c.Param("waitlist_entry_id"), "waitlist_status": models.WaitlistStatusWaiting},
+			bson.D{{Key: "$set", Value: updateObj}},
+			opts,
+		).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no waiting party was found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "waitlist update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// SeatWaitlistEntry seats a waiting party at the given table and opens its order.
+func SeatWaitlistEntry() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Table_id *string `json:"table_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		entryId := c.Param("waitlist_entry_id")
+		var entry models.WaitlistEntry
+		err := waitlistCollection.FindOne(ctx, bson.M{"waitlist_entry_id": entryId, "waitlist_status": models.WaitlistStatusWaiting}).Decode(&entry)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no waiting party was found"})
+			return
+		}
+
+		until := time.Now().Add(averageTurnMinutes * time.Minute)
+		_, err = seatParty(ctx, *body.Table_id, *entry.Party_size, c.GetString("user_id"), until, "", func(sessCtx mongo.SessionContext, orderId string) error {
+			now := time.Now()
+			Updated_at, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+			// Conditional on the party still waiting, so it is only seated once.
+			filter := bson.M{"waitlist_entry_id": entryId, "waitlist_status": models.WaitlistStatusWaiting}
+			opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+			err := waitlistCollection.FindOneAndUpdate(sessCtx, filter, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "waitlist_status", Value: models.WaitlistStatusSeated},
+					{Key: "table_id", Value: body.Table_id},
+					{Key: "order_id", Value: orderId},
+					{Key: "seated_at", Value: now},
+					{Key: "updated_at", Value: Updated_at},
+				}},
+			}, opts).Decode(&entry)
+			if err == mongo.ErrNoDocuments {
+				return &tableError{Status: http.StatusConflict, Code: "WAITLIST_CHANGED", Message: "the party was seated or left the waitlist meanwhile"}
+			}
+			return err
+		})
+		if err != nil {
+			respondTableError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func waitingParties(ctx context.Context) ([]models.WaitlistEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	result, err := waitlistCollection.Find(ctx, bson.M{"waitlist_status": models.WaitlistStatusWaiting}, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.WaitlistEntry{}
+	if err = result.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// quoteWait estimates in minutes how long a new party will wait. Each table
+// large enough for it is expected to free up averageTurnMinutes after its
+// party sat down, or after the bookings that would not leave it a whole turn,
+// and the parties already waiting that fit the same tables are served first,
+// one per table turn.
+func quoteWait(ctx context.Context, partySize int, now time.Time) (int, error) {
+	result, err := tableCollection.Find(ctx, bson.M{"seat_capacity": bson.M{"$gte": partySize}})
+	if err != nil {
+		return 0, err
+	}
+	var tables []models.Table
+	if err = result.All(ctx, &tables); err != nil {
+		return 0, err
+	}
+	if len(tables) == 0 {
+		return 0, &tableError{Status: http.StatusConflict, Code: "TABLE_TOO_SMALL", Message: fmt.Sprintf("no table seats %d", partySize)}
+	}
+
+	tableIds := make([]string, 0, len(tables))
+	for _, table := range tables {
+		tableIds = append(tableIds, table.Table_id)
+	}
+	bookings, err := upcomingBookings(ctx, tableIds, now)
+	if err != nil {
+		return 0, err
+	}
+
+	largest := 0
+	var freeIn []int
+	for _, table := range tables {
+		if *table.Seat_capacity > largest {
+			largest = *table.Seat_capacity
+		}
+		minutes := 0
+		status := models.TableStatusFree
+		if table.Table_status != nil {
+			status = *table.Table_status
+		}
+		switch status {
+		case models.TableStatusFree, models.TableStatusReserved:
+		case models.TableStatusNeedsCleaning:
+			minutes = 5
+		default:
+			minutes = averageTurnMinutes
+			if table.Seated_at != nil {
+				minutes -= int(now.Sub(*table.Seated_at).Minutes())
+			}
+			if minutes < 5 {
+				minutes = 5
+			}
+		}
+		// A walk-in only gets a table it can keep for a whole turn before
+		// the next booking of that table starts.
+		for _, booking := range bookings[table.Table_id] {
+			freeAt := now.Add(time.Duration(minutes) * time.Minute)
+			if !booking.Reserved_for.Before(freeAt.Add(averageTurnMinutes * time.Minute)) {
+				break
+			}
+			if booking.Ends_at.After(freeAt) {
+				minutes = int(booking.Ends_at.Sub(now).Minutes())
+			}
+		}
+		freeIn = append(freeIn, minutes)
+	}
+	sort.Ints(freeIn)
+
+	entries, err := waitingParties(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ahead := 0
+	for _, entry := range entries {
+		if *entry.Party_size <= largest {
+			ahead++
+		}
+	}
+
+	return freeIn[ahead%len(freeIn)] + (ahead/len(freeIn))*averageTurnMinutes, nil
+}
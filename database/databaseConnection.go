@@ -2,18 +2,28 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// DBinstance connects to the MongoDB deployment at MONGODB_URI, by default a
+// server on localhost. Table transfers, split bills, payments, refunds and
+// adjustments use multi-document transactions, so the deployment must be a
+// replica set (a single node one will do) or a sharded cluster; see
+// RequireTransactions.
 func DBinstance() *mongo.Client {
-	MongoDb := "mongodb://localhost:27017"
+	MongoDb := os.Getenv("MONGODB_URI")
+	if MongoDb == "" {
+		MongoDb = "mongodb://localhost:27017"
+	}
 
-	fmt.Println(MongoDb)
 	client, err := mongo.NewClient(options.Client().ApplyURI(MongoDb))
 
 	if err != nil {
@@ -33,7 +43,47 @@ func DBinstance() *mongo.Client {
 
 var Client *mongo.Client = DBinstance()
 
-func OpenCollection(client *mongo.Client,collectionName string) *mongo.Collection{
+func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 	var collection *mongo.Collection = client.Database("restaurant").Collection(collectionName)
 	return collection
-}
\ No newline at end of file
+}
+
+// RequireTransactions checks that the deployment supports transactions: it
+// must be a replica set member or a mongos router. A standalone server
+// accepts every other request, so without this check the endpoints relying
+// on transactions would only fail once called.
+func RequireTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("MongoDB is a standalone server, transactions need a replica set: start mongod with --replSet and run rs.initiate(), then point MONGODB_URI at it")
+	}
+	return nil
+}
+
+// EnsureIndexes creates the unique indexes the service relies on: no two
+// users may share an email or a phone number. Creating an index that
+// already exists does nothing; it fails if stored users already share one.
+func EnsureIndexes(ctx context.Context) error {
+	users := OpenCollection(Client, "user")
+	_, err := users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true).SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys:    bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetName("phone_unique").SetUnique(true).SetPartialFilterExpression(bson.M{"phone": bson.M{"$type": "string"}}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating the unique indexes of users, check for users sharing an email or a phone number: %w", err)
+	}
+	return nil
+}
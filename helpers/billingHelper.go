@@ -0,0 +1,343 @@
+package helper
+
+import (
+	"math"
+	"sort"
+)
+
+// Amounts in this file are integer minor units of the currency (cents) and
+// tax rates are parts per million (8.875% is 88750), so bills add up to the
+// cent whatever the order of the lines.
+
+const ppm = 1_000_000
+
+// ToMinor converts a price in major units to minor units, rounding half away
+// from zero.
+func ToMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromMinor converts an amount in minor units to major units for display.
+func FromMinor(amount int64) float64 {
+	return float64(amount) / 100
+}
+
+// RateToPpm converts a percentage to parts per million.
+func RateToPpm(percent float64) int64 {
+	return int64(math.Round(percent * 10_000))
+}
+
+// BillTaxRate is a tax charged on a bill line.
+type BillTaxRate struct {
+	Tax_rate_id string
+	Name        string
+	Rate_ppm    int64
+}
+
+// BillLine is one billed order line: its price times its quantity, and the
+// taxes charged on it.
+type BillLine struct {
+	Amount    int64
+	Tax_rates []BillTaxRate
+}
+
+// BillInput is everything a bill is computed from.
+type BillInput struct {
+	Lines []BillLine
+	// Tax_inclusive is set when line amounts already include their taxes.
+	Tax_inclusive bool
+	// Service_charge_ppm is charged on the subtotal after discount, untaxed.
+	Service_charge_ppm int64
+	Discount           int64
+	Tip                int64
+	// Cash_rounding rounds the total to the nearest multiple, e.g. 5 for
+	// 0.05; zero or one disables it.
+	Cash_rounding int64
+}
+
+// BillTax is the amount charged for one tax rate over the bill.
+type BillTax struct {
+	Tax_rate_id string `json:"tax_rate_id"`
+	Name        string `json:"name"`
+	Rate_ppm    int64  `json:"rate_ppm"`
+	Taxable     int64  `json:"taxable"`
+	Amount      int64  `json:"amount"`
+}
+
+// Bill is the breakdown of an invoice.
+type Bill struct {
+	Subtotal       int64     `json:"subtotal"`
+	Discount       int64     `json:"discount"`
+	Service_charge int64     `json:"service_charge"`
+	Taxes          []BillTax `json:"taxes"`
+	Tax_total      int64     `json:"tax_total"`
+	Tip            int64     `json:"tip"`
+	Rounding       int64     `json:"rounding"`
+	Total          int64     `json:"total"`
+}
+
+// ComputeBill works out the breakdown of a bill. The discount is spread over
+// the lines in proportion to their amount before taxes are worked out, so
+// each tax is charged on what the guest actually pays. Lines charged the same
+// rates are taxed together and each tax is rounded half away from zero.
+func ComputeBill(input BillInput) Bill {
+	var bill Bill
+
+	amounts := make([]int64, len(input.Lines))
+	for i, line := range input.Lines {
+		amounts[i] = line.Amount
+		bill.Subtotal += line.Amount
+	}
+
+	bill.Discount = input.Discount
+	if bill.Discount < 0 {
+		bill.Discount = 0
+	}
+	if bill.Discount > bill.Subtotal {
+		bill.Discount = bill.Subtotal
+	}
+	discounts := Allocate(bill.Discount, amounts)
+	for i := range amounts {
+		amounts[i] -= discounts[i]
+	}
+	discounted := bill.Subtotal - bill.Discount
+
+	// Lines charged the same rates are taxed together.
+	type group struct {
+		amount int64
+		rates  []BillTaxRate
+	}
+	groups := map[string]*group{}
+	var keys []string
+	for i, line := range input.Lines {
+		key := ""
+		for _, rate := range line.Tax_rates {
+			key += rate.Tax_rate_id + ";"
+		}
+		if groups[key] == nil {
+			groups[key] = &group{rates: line.Tax_rates}
+			keys = append(keys, key)
+		}
+		groups[key].amount += amounts[i]
+	}
+
+	taxes := map[string]*BillTax{}
+	var order []string
+	for _, key := range keys {
+		g := groups[key]
+		var combined int64
+		for _, rate := range g.rates {
+			combined += rate.Rate_ppm
+		}
+		for _, rate := range g.rates {
+			tax := taxes[rate.Tax_rate_id]
+			if tax == nil {
+				tax = &BillTax{Tax_rate_id: rate.Tax_rate_id, Name: rate.Name, Rate_ppm: rate.Rate_ppm}
+				taxes[rate.Tax_rate_id] = tax
+				order = append(order, rate.Tax_rate_id)
+			}
+			if input.Tax_inclusive {
+				tax.Taxable += divRound(g.amount*ppm, ppm+combined)
+				tax.Amount += divRound(g.amount*rate.Rate_ppm, ppm+combined)
+			} else {
+				tax.Taxable += g.amount
+				tax.Amount += divRound(g.amount*rate.Rate_ppm, ppm)
+			}
+		}
+	}
+	sort.Strings(order)
+	bill.Taxes = []BillTax{}
+	for _, id := range order {
+		bill.Taxes = append(bill.Taxes, *taxes[id])
+		bill.Tax_total += taxes[id].Amount
+	}
+
+	bill.Service_charge = divRound(discounted*input.Service_charge_ppm, ppm)
+	if input.Tip > 0 {
+		bill.Tip = input.Tip
+	}
+
+	total := discounted + bill.Service_charge + bill.Tip
+	if !input.Tax_inclusive {
+		total += bill.Tax_total
+	}
+	if input.Cash_rounding > 1 {
+		rounded := divRound(total, input.Cash_rounding) * input.Cash_rounding
+		bill.Rounding = rounded - total
+		total = rounded
+	}
+	bill.Total = total
+
+	return bill
+}
+
+// SplitBill splits a bill computed from input into one bill per share, each
+// share listing the indexes of the lines it pays for. A line listed by several
+// shares is divided evenly between them. Every component of the bill is
+// allocated over the shares in proportion to what they pay for, so the split
+// bills add up exactly to bill, provided every line is in at least one share.
+// Cash rounding is applied to the bill as a whole and allocated as well.
+func SplitBill(input BillInput, bill Bill, shares [][]int) []Bill {
+	holders := make([][]int, len(input.Lines))
+	for i, share := range shares {
+		for _, line := range share {
+			holders[line] = append(holders[line], i)
+		}
+	}
+
+	// portions[i][j] is the part of line j paid by share i.
+	portions := make([][]int64, len(shares))
+	for i := range portions {
+		portions[i] = make([]int64, len(input.Lines))
+	}
+	for j, line := range input.Lines {
+		for k, part := range Allocate(line.Amount, make([]int64, len(holders[j]))) {
+			portions[holders[j][k]][j] = part
+		}
+	}
+
+	subtotals := make([]int64, len(shares))
+	for i := range shares {
+		for _, part := range portions[i] {
+			subtotals[i] += part
+		}
+	}
+	discounts := Allocate(bill.Discount, subtotals)
+	discounted := make([]int64, len(shares))
+	for i := range shares {
+		discounted[i] = subtotals[i] - discounts[i]
+	}
+	serviceCharges := Allocate(bill.Service_charge, discounted)
+	tips := Allocate(bill.Tip, subtotals)
+
+	bills := make([]Bill, len(shares))
+	for i := range bills {
+		bills[i] = Bill{
+			Subtotal:       subtotals[i],
+			Discount:       discounts[i],
+			Service_charge: serviceCharges[i],
+			Taxes:          []BillTax{},
+			Tip:            tips[i],
+		}
+	}
+
+	for _, tax := range bill.Taxes {
+		weights := make([]int64, len(shares))
+		for j, line := range input.Lines {
+			for _, rate := range line.Tax_rates {
+				if rate.Tax_rate_id != tax.Tax_rate_id {
+					continue
+				}
+				for i := range shares {
+					weights[i] += portions[i][j]
+				}
+			}
+		}
+		taxables := Allocate(tax.Taxable, weights)
+		amounts := Allocate(tax.Amount, weights)
+		for i := range bills {
+			if taxables[i] == 0 && amounts[i] == 0 {
+				continue
+			}
+			share := tax
+			share.Taxable = taxables[i]
+			share.Amount = amounts[i]
+			bills[i].Taxes = append(bills[i].Taxes, share)
+			bills[i].Tax_total += amounts[i]
+		}
+	}
+
+	totals := make([]int64, len(shares))
+	for i, b := range bills {
+		totals[i] = b.Subtotal - b.Discount + b.Service_charge + b.Tip
+		if !input.Tax_inclusive {
+			totals[i] += b.Tax_total
+		}
+	}
+	roundings := Allocate(bill.Rounding, totals)
+	for i := range bills {
+		bills[i].Rounding = roundings[i]
+		bills[i].Total = totals[i] + roundings[i]
+	}
+
+	return bills
+}
+
+// Allocate splits amount over parts in proportion to their weights using the
+// largest remainder method, so the parts always add up to amount exactly.
+// With no positive weight the amount is split evenly.
+func Allocate(amount int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	if amount < 0 {
+		for i, part := range Allocate(-amount, weights) {
+			parts[i] = -part
+		}
+		return parts
+	}
+
+	var total int64
+	for _, weight := range weights {
+		if weight > 0 {
+			total += weight
+		}
+	}
+	if total == 0 {
+		weights = make([]int64, len(parts))
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = int64(len(weights))
+	}
+
+	type remainder struct {
+		index int
+		value int64
+	}
+	remainders := make([]remainder, 0, len(weights))
+	var allocated int64
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		parts[i] = amount * weight / total
+		allocated += parts[i]
+		remainders = append(remainders, remainder{i, amount * weight % total})
+	}
+	sort.SliceStable(remainders, func(i, j int) bool {
+		return remainders[i].value > remainders[j].value
+	})
+	for i := int64(0); i < amount-allocated; i++ {
+		parts[remainders[int(i)%len(remainders)].index]++
+	}
+	return parts
+}
+
+// divRound divides rounding half away from zero.
+func divRound(numerator int64, denominator int64) int64 {
+	if denominator == 0 {
+		return 0
+	}
+	quotient := numerator / denominator
+	remainder := numerator % denominator
+	if remainder < 0 {
+		remainder = -remainder
+	}
+	if 2*remainder >= abs(denominator) {
+		if (numerator < 0) != (denominator < 0) {
+			quotient--
+		} else {
+			quotient++
+		}
+	}
+	return quotient
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,307 @@
+package helper
+
+import "testing"
+
+var (
+	vat  = BillTaxRate{Tax_rate_id: "vat", Name: "VAT", Rate_ppm: 100_000}
+	city = BillTaxRate{Tax_rate_id: "city", Name: "City tax", Rate_ppm: 8_875}
+)
+
+func TestComputeBill(t *testing.T) {
+	tests := []struct {
+		name  string
+		input BillInput
+		want  Bill
+	}{
+		{
+			name: "exclusive tax",
+			input: BillInput{Lines: []BillLine{
+				{Amount: 1000, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 2000, Tax_rates: []BillTaxRate{vat}},
+			}},
+			want: Bill{Subtotal: 3000, Tax_total: 300, Total: 3300},
+		},
+		{
+			name: "inclusive tax",
+			input: BillInput{Tax_inclusive: true, Lines: []BillLine{
+				{Amount: 1100, Tax_rates: []BillTaxRate{vat}},
+			}},
+			want: Bill{Subtotal: 1100, Tax_total: 100, Total: 1100},
+		},
+		{
+			name: "inclusive tax with two rates",
+			input: BillInput{Tax_inclusive: true, Lines: []BillLine{
+				{Amount: 10_000, Tax_rates: []BillTaxRate{vat, city}},
+			}},
+			// 10000 / 1.108875 = 9018.15 taxable, 901.82 VAT and 80.04 city tax.
+			want: Bill{Subtotal: 10_000, Tax_total: 982, Total: 10_000},
+		},
+		{
+			name: "untaxed line",
+			input: BillInput{Lines: []BillLine{
+				{Amount: 1000, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 500},
+			}},
+			want: Bill{Subtotal: 1500, Tax_total: 100, Total: 1600},
+		},
+		{
+			name: "discount is taxed away",
+			input: BillInput{Discount: 1000, Lines: []BillLine{
+				{Amount: 3000, Tax_rates: []BillTaxRate{vat}},
+			}},
+			want: Bill{Subtotal: 3000, Discount: 1000, Tax_total: 200, Total: 2200},
+		},
+		{
+			name: "discount is capped at the subtotal",
+			input: BillInput{Discount: 5000, Service_charge_ppm: 100_000, Tip: 200, Lines: []BillLine{
+				{Amount: 1000, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 500},
+			}},
+			want: Bill{Subtotal: 1500, Discount: 1500, Tip: 200, Total: 200},
+		},
+		{
+			name: "negative discount is ignored",
+			input: BillInput{Discount: -100, Lines: []BillLine{
+				{Amount: 1000},
+			}},
+			want: Bill{Subtotal: 1000, Total: 1000},
+		},
+		{
+			name: "service charge on the discounted subtotal",
+			input: BillInput{Discount: 500, Service_charge_ppm: 125_000, Lines: []BillLine{
+				{Amount: 2500, Tax_rates: []BillTaxRate{vat}},
+			}},
+			want: Bill{Subtotal: 2500, Discount: 500, Service_charge: 250, Tax_total: 200, Total: 2450},
+		},
+		{
+			name: "cash rounding up",
+			input: BillInput{Cash_rounding: 5, Lines: []BillLine{
+				{Amount: 1003},
+			}},
+			want: Bill{Subtotal: 1003, Rounding: 2, Total: 1005},
+		},
+		{
+			name: "cash rounding down",
+			input: BillInput{Cash_rounding: 5, Lines: []BillLine{
+				{Amount: 1002},
+			}},
+			want: Bill{Subtotal: 1002, Rounding: -2, Total: 1000},
+		},
+		{
+			name: "cash rounding after tax and tip",
+			input: BillInput{Cash_rounding: 10, Tip: 150, Lines: []BillLine{
+				{Amount: 1234, Tax_rates: []BillTaxRate{vat}},
+			}},
+			// 1234 + 123 tax + 150 tip = 1507.
+			want: Bill{Subtotal: 1234, Tax_total: 123, Tip: 150, Rounding: 3, Total: 1510},
+		},
+		{
+			name: "cash rounding of one is ignored",
+			input: BillInput{Cash_rounding: 1, Lines: []BillLine{
+				{Amount: 1003},
+			}},
+			want: Bill{Subtotal: 1003, Total: 1003},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeBill(tt.input)
+			if got.Subtotal != tt.want.Subtotal || got.Discount != tt.want.Discount ||
+				got.Service_charge != tt.want.Service_charge || got.Tax_total != tt.want.Tax_total ||
+				got.Tip != tt.want.Tip || got.Rounding != tt.want.Rounding || got.Total != tt.want.Total {
+				t.Errorf("ComputeBill() = %+v, want %+v", got, tt.want)
+			}
+
+			var taxes int64
+			for _, tax := range got.Taxes {
+				taxes += tax.Amount
+			}
+			if taxes != got.Tax_total {
+				t.Errorf("taxes add up to %d, Tax_total is %d", taxes, got.Tax_total)
+			}
+		})
+	}
+}
+
+func TestComputeBillTaxBreakdown(t *testing.T) {
+	got := ComputeBill(BillInput{Tax_inclusive: true, Lines: []BillLine{
+		{Amount: 10_000, Tax_rates: []BillTaxRate{vat, city}},
+	}})
+
+	want := []BillTax{
+		{Tax_rate_id: "city", Name: "City tax", Rate_ppm: 8_875, Taxable: 9018, Amount: 80},
+		{Tax_rate_id: "vat", Name: "VAT", Rate_ppm: 100_000, Taxable: 9018, Amount: 902},
+	}
+	if len(got.Taxes) != len(want) {
+		t.Fatalf("Taxes = %+v, want %+v", got.Taxes, want)
+	}
+	for i := range want {
+		if got.Taxes[i] != want[i] {
+			t.Errorf("Taxes[%d] = %+v, want %+v", i, got.Taxes[i], want[i])
+		}
+	}
+}
+
+func TestSplitBill(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  BillInput
+		shares [][]int
+	}{
+		{
+			name: "by item",
+			input: BillInput{Lines: []BillLine{
+				{Amount: 1299, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 850, Tax_rates: []BillTaxRate{vat, city}},
+				{Amount: 400},
+			}},
+			shares: [][]int{{0}, {1, 2}},
+		},
+		{
+			// Seats 1 and 2 each have an item; the bread and the wine have no
+			// seat and are shared by both seats.
+			name: "by seat with unseated items",
+			input: BillInput{Service_charge_ppm: 100_000, Lines: []BillLine{
+				{Amount: 1850, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 2375, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 499, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 3601, Tax_rates: []BillTaxRate{vat, city}},
+			}},
+			shares: [][]int{{0, 2, 3}, {1, 2, 3}},
+		},
+		{
+			name: "item taken by several payers",
+			input: BillInput{Discount: 333, Tip: 500, Lines: []BillLine{
+				{Amount: 1000, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 2999, Tax_rates: []BillTaxRate{vat, city}},
+			}},
+			shares: [][]int{{0, 1}, {1}, {1}},
+		},
+		{
+			name: "equal shares with cash rounding",
+			input: BillInput{Cash_rounding: 5, Service_charge_ppm: 125_000, Tip: 101, Lines: []BillLine{
+				{Amount: 1001, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 2002, Tax_rates: []BillTaxRate{city}},
+				{Amount: 707},
+			}},
+			shares: [][]int{{0, 1, 2}, {0, 1, 2}, {0, 1, 2}},
+		},
+		{
+			name: "inclusive tax with a capped discount",
+			input: BillInput{Tax_inclusive: true, Discount: 10_000, Lines: []BillLine{
+				{Amount: 1100, Tax_rates: []BillTaxRate{vat}},
+				{Amount: 2200, Tax_rates: []BillTaxRate{vat}},
+			}},
+			shares: [][]int{{0}, {1}},
+		},
+		{
+			name: "inclusive tax split seven ways",
+			input: BillInput{Tax_inclusive: true, Cash_rounding: 10, Lines: []BillLine{
+				{Amount: 9999, Tax_rates: []BillTaxRate{vat, city}},
+				{Amount: 1, Tax_rates: []BillTaxRate{vat}},
+			}},
+			shares: [][]int{{0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}, {0, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bill := ComputeBill(tt.input)
+			split := SplitBill(tt.input, bill, tt.shares)
+			if len(split) != len(tt.shares) {
+				t.Fatalf("got %d bills for %d shares", len(split), len(tt.shares))
+			}
+
+			var sum Bill
+			taxes := map[string]BillTax{}
+			for _, part := range split {
+				sum.Subtotal += part.Subtotal
+				sum.Discount += part.Discount
+				sum.Service_charge += part.Service_charge
+				sum.Tax_total += part.Tax_total
+				sum.Tip += part.Tip
+				sum.Rounding += part.Rounding
+				sum.Total += part.Total
+
+				var partTaxes int64
+				for _, tax := range part.Taxes {
+					partTaxes += tax.Amount
+					total := taxes[tax.Tax_rate_id]
+					total.Taxable += tax.Taxable
+					total.Amount += tax.Amount
+					taxes[tax.Tax_rate_id] = total
+				}
+				if partTaxes != part.Tax_total {
+					t.Errorf("taxes of %+v add up to %d", part, partTaxes)
+				}
+
+				total := part.Subtotal - part.Discount + part.Service_charge + part.Tip + part.Rounding
+				if !tt.input.Tax_inclusive {
+					total += part.Tax_total
+				}
+				if total != part.Total {
+					t.Errorf("components of %+v add up to %d", part, total)
+				}
+			}
+
+			if sum.Total != bill.Total {
+				t.Errorf("split totals add up to %d, bill total is %d", sum.Total, bill.Total)
+			}
+			if sum.Subtotal != bill.Subtotal || sum.Discount != bill.Discount || sum.Service_charge != bill.Service_charge ||
+				sum.Tax_total != bill.Tax_total || sum.Tip != bill.Tip || sum.Rounding != bill.Rounding {
+				t.Errorf("split bills add up to %+v, bill is %+v", sum, bill)
+			}
+			for _, tax := range bill.Taxes {
+				if got := taxes[tax.Tax_rate_id]; got.Taxable != tax.Taxable || got.Amount != tax.Amount {
+					t.Errorf("%s splits add up to %+v, bill has %+v", tax.Tax_rate_id, got, tax)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitBillSharedLine(t *testing.T) {
+	input := BillInput{Lines: []BillLine{{Amount: 1000}}}
+	split := SplitBill(input, ComputeBill(input), [][]int{{0}, {0}, {0}})
+
+	// 1000 over three payers: one of them pays the extra cent.
+	want := []int64{334, 333, 333}
+	for i, part := range split {
+		if part.Total != want[i] {
+			t.Errorf("share %d pays %d, want %d", i, part.Total, want[i])
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{"proportional", 100, []int64{1, 3}, []int64{25, 75}},
+		{"largest remainder", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"remainder to the largest fraction", 10, []int64{2, 3, 5}, []int64{2, 3, 5}},
+		{"uneven remainders", 7, []int64{10, 25, 65}, []int64{1, 2, 4}},
+		{"no positive weight", 5, []int64{0, 0}, []int64{3, 2}},
+		{"zero weight gets nothing", 9, []int64{0, 1, 2}, []int64{0, 3, 6}},
+		{"negative amount", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"no parts", 100, nil, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Allocate(tt.amount, tt.weights)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.weights, got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.weights, got, tt.want)
+				}
+			}
+		})
+	}
+}
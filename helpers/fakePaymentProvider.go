@@ -0,0 +1,138 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeProviderName is the name of the local payment simulator.
+const FakeProviderName = "fake"
+
+// Card tokens the fake provider answers to in a set way. Any other token is
+// approved.
+const (
+	FakeCardDeclined          = "tok_declined"
+	FakeCardInsufficientFunds = "tok_insufficient_funds"
+	FakeCardTimeout           = "tok_timeout"
+)
+
+// FakePaymentProvider simulates a payment gateway in memory, for tests and
+// demos. Its answers only depend on the card token and the calls made before,
+// and transaction ids are numbered in order, so runs can be replayed. Its
+// transactions are lost when the server restarts.
+type FakePaymentProvider struct {
+	mu           sync.Mutex
+	sequence     int
+	transactions map[string]*fakeTransaction
+	results      map[string]ProviderResult
+}
+
+type fakeTransaction struct {
+	authorized int64
+	captured   int64
+	refunded   int64
+	voided     bool
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{
+		transactions: map[string]*fakeTransaction{},
+		results:      map[string]ProviderResult{},
+	}
+}
+
+func (p *FakePaymentProvider) Name() string {
+	return FakeProviderName
+}
+
+func (p *FakePaymentProvider) Authorize(ctx context.Context, request AuthorizeRequest) (ProviderResult, error) {
+	return p.idempotent("authorize", request.Idempotency_key, func() (ProviderResult, error) {
+		switch request.Card_token {
+		case FakeCardTimeout:
+			return ProviderResult{}, ErrProviderTimeout
+		case FakeCardDeclined:
+			return p.declined("card_declined"), nil
+		case FakeCardInsufficientFunds:
+			return p.declined("insufficient_funds"), nil
+		}
+		if request.Amount <= 0 {
+			return p.declined("invalid_amount"), nil
+		}
+
+		result := p.approved()
+		p.transactions[result.Transaction_id] = &fakeTransaction{authorized: request.Amount}
+		return result, nil
+	})
+}
+
+func (p *FakePaymentProvider) Capture(ctx context.Context, transactionId string, amount int64, idempotencyKey string) (ProviderResult, error) {
+	return p.idempotent("capture", idempotencyKey, func() (ProviderResult, error) {
+		transaction, ok := p.transactions[transactionId]
+		if !ok || transaction.voided || transaction.captured > 0 {
+			return ProviderResult{}, ErrUnknownTransaction
+		}
+		if amount <= 0 || amount > transaction.authorized {
+			return p.declined("amount_exceeds_authorization"), nil
+		}
+
+		transaction.captured = amount
+		return ProviderResult{Transaction_id: transactionId, Status: ProviderApproved}, nil
+	})
+}
+
+func (p *FakePaymentProvider) Refund(ctx context.Context, transactionId string, amount int64, idempotencyKey string) (ProviderResult, error) {
+	return p.idempotent("refund", idempotencyKey, func() (ProviderResult, error) {
+		transaction, ok := p.transactions[transactionId]
+		if !ok || transaction.captured == 0 {
+			return ProviderResult{}, ErrUnknownTransaction
+		}
+		if amount <= 0 || transaction.refunded+amount > transaction.captured {
+			return p.declined("amount_exceeds_capture"), nil
+		}
+
+		transaction.refunded += amount
+		return p.approved(), nil
+	})
+}
+
+func (p *FakePaymentProvider) Void(ctx context.Context, transactionId string, idempotencyKey string) (ProviderResult, error) {
+	return p.idempotent("void", idempotencyKey, func() (ProviderResult, error) {
+		transaction, ok := p.transactions[transactionId]
+		if !ok || transaction.captured > 0 {
+			return ProviderResult{}, ErrUnknownTransaction
+		}
+
+		transaction.voided = true
+		return ProviderResult{Transaction_id: transactionId, Status: ProviderApproved}, nil
+	})
+}
+
+// idempotent runs an operation once per idempotency key and replays its
+// result after; without a key it always runs. Errors are not kept, so the
+// operation can be retried after a timeout.
+func (p *FakePaymentProvider) idempotent(operation string, idempotencyKey string, run func() (ProviderResult, error)) (ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := operation + ":" + idempotencyKey
+	if result, ok := p.results[key]; ok && idempotencyKey != "" {
+		return result, nil
+	}
+	result, err := run()
+	if err != nil || idempotencyKey == "" {
+		return result, err
+	}
+	p.results[key] = result
+	return result, nil
+}
+
+func (p *FakePaymentProvider) approved() ProviderResult {
+	p.sequence++
+	return ProviderResult{Transaction_id: fmt.Sprintf("fake_txn_%06d", p.sequence), Status: ProviderApproved}
+}
+
+func (p *FakePaymentProvider) declined(code string) ProviderResult {
+	p.sequence++
+	return ProviderResult{Transaction_id: fmt.Sprintf("fake_txn_%06d", p.sequence), Status: ProviderDeclined, Decline_code: code}
+}
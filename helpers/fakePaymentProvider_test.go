@@ -0,0 +1,162 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakePaymentProviderAuthorize(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		amount      int64
+		wantStatus  string
+		wantDecline string
+		wantErr     error
+	}{
+		{name: "approved", token: "tok_visa", amount: 2500, wantStatus: ProviderApproved},
+		{name: "declined", token: FakeCardDeclined, amount: 2500, wantStatus: ProviderDeclined, wantDecline: "card_declined"},
+		{name: "insufficient funds", token: FakeCardInsufficientFunds, amount: 2500, wantStatus: ProviderDeclined, wantDecline: "insufficient_funds"},
+		{name: "timeout", token: FakeCardTimeout, amount: 2500, wantErr: ErrProviderTimeout},
+		{name: "zero amount", token: "tok_visa", amount: 0, wantStatus: ProviderDeclined, wantDecline: "invalid_amount"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewFakePaymentProvider()
+			result, err := provider.Authorize(context.Background(), AuthorizeRequest{Amount: tt.amount, Currency: "USD", Card_token: tt.token, Idempotency_key: "payment-1"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authorize() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result.Status != tt.wantStatus || result.Decline_code != tt.wantDecline {
+				t.Errorf("Authorize() = %+v, want status %s and decline code %q", result, tt.wantStatus, tt.wantDecline)
+			}
+			if result.Transaction_id != "fake_txn_000001" {
+				t.Errorf("Authorize() transaction id = %s, want fake_txn_000001", result.Transaction_id)
+			}
+		})
+	}
+}
+
+func TestFakePaymentProviderIdempotency(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakePaymentProvider()
+	request := AuthorizeRequest{Amount: 2500, Currency: "USD", Card_token: "tok_visa", Idempotency_key: "payment-1"}
+
+	first, err := provider.Authorize(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := provider.Authorize(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("retried Authorize() = %+v, want %+v", again, first)
+	}
+
+	request.Idempotency_key = "payment-2"
+	other, err := provider.Authorize(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Transaction_id == first.Transaction_id {
+		t.Errorf("another key got the same transaction %s", other.Transaction_id)
+	}
+
+	// Timeouts are not kept: the same key is tried again.
+	request = AuthorizeRequest{Amount: 2500, Card_token: FakeCardTimeout, Idempotency_key: "payment-3"}
+	if _, err = provider.Authorize(ctx, request); !errors.Is(err, ErrProviderTimeout) {
+		t.Fatalf("Authorize() error = %v, want %v", err, ErrProviderTimeout)
+	}
+	request.Card_token = "tok_visa"
+	if result, err := provider.Authorize(ctx, request); err != nil || result.Status != ProviderApproved {
+		t.Fatalf("Authorize() after a timeout = %+v, %v", result, err)
+	}
+}
+
+func TestFakePaymentProviderLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	type step struct {
+		operation   string
+		amount      int64
+		key         string
+		wantStatus  string
+		wantDecline string
+		wantErr     error
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "capture then refund in parts",
+			steps: []step{
+				{operation: "capture", amount: 2500, key: "c1", wantStatus: ProviderApproved},
+				{operation: "refund", amount: 1000, key: "r1", wantStatus: ProviderApproved},
+				{operation: "refund", amount: 1000, key: "r1", wantStatus: ProviderApproved},
+				{operation: "refund", amount: 1500, key: "r2", wantStatus: ProviderApproved},
+				{operation: "refund", amount: 1, key: "r3", wantStatus: ProviderDeclined, wantDecline: "amount_exceeds_capture"},
+			},
+		},
+		{
+			name: "capture beyond the authorization",
+			steps: []step{
+				{operation: "capture", amount: 3000, key: "c1", wantStatus: ProviderDeclined, wantDecline: "amount_exceeds_authorization"},
+			},
+		},
+		{
+			name: "refund before capture",
+			steps: []step{
+				{operation: "refund", amount: 1000, key: "r1", wantErr: ErrUnknownTransaction},
+			},
+		},
+		{
+			name: "void releases the authorization",
+			steps: []step{
+				{operation: "void", key: "v1", wantStatus: ProviderApproved},
+				{operation: "capture", amount: 2500, key: "c1", wantErr: ErrUnknownTransaction},
+			},
+		},
+		{
+			name: "captured payments cannot be voided",
+			steps: []step{
+				{operation: "capture", amount: 2500, key: "c1", wantStatus: ProviderApproved},
+				{operation: "void", key: "v1", wantErr: ErrUnknownTransaction},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewFakePaymentProvider()
+			authorized, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 2500, Card_token: "tok_visa", Idempotency_key: "a1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				var result ProviderResult
+				switch s.operation {
+				case "capture":
+					result, err = provider.Capture(ctx, authorized.Transaction_id, s.amount, s.key)
+				case "refund":
+					result, err = provider.Refund(ctx, authorized.Transaction_id, s.amount, s.key)
+				case "void":
+					result, err = provider.Void(ctx, authorized.Transaction_id, s.key)
+				}
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d %s: error = %v, want %v", i, s.operation, err, s.wantErr)
+				}
+				if s.wantErr == nil && (result.Status != s.wantStatus || result.Decline_code != s.wantDecline) {
+					t.Fatalf("step %d %s: %+v, want status %s and decline code %q", i, s.operation, result, s.wantStatus, s.wantDecline)
+				}
+			}
+		})
+	}
+}
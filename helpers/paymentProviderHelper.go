@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// Outcomes of a payment provider operation.
+const (
+	ProviderApproved = "APPROVED"
+	ProviderDeclined = "DECLINED"
+)
+
+// ErrProviderTimeout is returned when the provider did not answer in time;
+// the outcome of the operation is then unknown.
+var ErrProviderTimeout = errors.New("the payment provider did not answer in time")
+
+// ErrUnknownTransaction is returned for a transaction the provider does not
+// know of, or that is not in a state allowing the operation.
+var ErrUnknownTransaction = errors.New("the payment provider does not know this transaction")
+
+// AuthorizeRequest asks a provider to hold an amount on a card. The
+// idempotency key makes retries of the same request return the same result.
+type AuthorizeRequest struct {
+	Amount          int64
+	Currency        string
+	Card_token      string
+	Reference       string
+	Idempotency_key string
+}
+
+// ProviderResult is the answer of a provider to an operation. Declines are
+// results, not errors, and carry the provider's reason in Decline_code.
+type ProviderResult struct {
+	Transaction_id string
+	Status         string
+	Decline_code   string
+}
+
+// PaymentProvider is a card payment gateway. Amounts are in minor units.
+type PaymentProvider interface {
+	Name() string
+	// Authorize holds an amount on a card.
+	Authorize(ctx context.Context, request AuthorizeRequest) (ProviderResult, error)
+	// Capture takes an authorized amount, or part of it.
+	Capture(ctx context.Context, transactionId string, amount int64, idempotencyKey string) (ProviderResult, error)
+	// Refund gives back some of a captured amount.
+	Refund(ctx context.Context, transactionId string, amount int64, idempotencyKey string) (ProviderResult, error)
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, transactionId string, idempotencyKey string) (ProviderResult, error)
+}
+
+// NewPaymentProvider returns the provider named by PAYMENT_PROVIDER. Only the
+// local simulator, "fake", is available so far and is the default.
+func NewPaymentProvider() PaymentProvider {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "", FakeProviderName:
+		return NewFakePaymentProvider()
+	default:
+		log.Fatalf("unknown payment provider %q", name)
+		return nil
+	}
+}
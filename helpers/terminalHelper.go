@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"restaurant-management/database"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrTerminalInvalid is returned when a terminal is unknown, disabled or its
+// key does not match.
+var ErrTerminalInvalid = errors.New("terminal is not registered")
+
+var terminalCollection *mongo.Collection = database.OpenCollection(database.Client, "terminal")
+
+// verifiedTerminalKeys remembers, per terminal, a digest of the last key that
+// matched its stored hash, so that bcrypt does not run on every request.
+// An entry only counts while the stored hash is unchanged.
+var verifiedTerminalKeys = struct {
+	sync.Mutex
+	keys map[string]verifiedTerminalKey
+}{keys: map[string]verifiedTerminalKey{}}
+
+type verifiedTerminalKey struct {
+	hash   string
+	digest [sha256.Size]byte
+}
+
+// VerifyTerminal checks that terminalId is a registered, active terminal and
+// that key is its secret. Only the device holds the key, so a token bound to
+// a terminal cannot be replayed from elsewhere.
+func VerifyTerminal(ctx context.Context, terminalId string, key string) error {
+	if terminalId == "" || key == "" {
+		return ErrTerminalInvalid
+	}
+
+	var terminal struct {
+		Terminal_key *string `bson:"terminal_key"`
+		Active       *bool   `bson:"active"`
+	}
+	err := terminalCollection.FindOne(ctx, bson.M{"terminal_id": terminalId}).Decode(&terminal)
+	if err != nil || terminal.Active == nil || !*terminal.Active || terminal.Terminal_key == nil {
+		return ErrTerminalInvalid
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	verifiedTerminalKeys.Lock()
+	cached, ok := verifiedTerminalKeys.keys[terminalId]
+	verifiedTerminalKeys.Unlock()
+	if ok && cached.hash == *terminal.Terminal_key && subtle.ConstantTimeCompare(cached.digest[:], digest[:]) == 1 {
+		return nil
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(*terminal.Terminal_key), []byte(key)); err != nil {
+		return ErrTerminalInvalid
+	}
+
+	verifiedTerminalKeys.Lock()
+	verifiedTerminalKeys.keys[terminalId] = verifiedTerminalKey{hash: *terminal.Terminal_key, digest: digest}
+	verifiedTerminalKeys.Unlock()
+	return nil
+}
@@ -1,13 +1,345 @@
 package helper
 
-func GenerateTokens() {
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"restaurant-management/database"
+	"time"
 
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	AccessTokenType       = "access"
+	RefreshTokenType      = "refresh"
+	TableSessionTokenType = "table_session"
+
+	AccessTokenLifetime       = 24 * time.Hour
+	RefreshTokenLifetime      = 7 * 24 * time.Hour
+	TerminalTokenLifetime     = 30 * time.Minute
+	TableSessionTokenLifetime = 4 * time.Hour
+)
+
+// SignedDetails are the claims carried by every token issued by the service.
+type SignedDetails struct {
+	User_id     string
+	Email       string
+	Role        string
+	Token_type  string
+	Terminal_id string
+	Table_id    string
+	// Token_version is the user's token version when the token was issued.
+	// Bumping the version on the user revokes every token issued before.
+	Token_version int
+	jwt.StandardClaims
 }
 
-func UpdateAllToken() {
+// Validation errors returned by ValidateToken and ValidateRefreshToken.
+var (
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenInvalid          = errors.New("token is invalid")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+)
+
+var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
+var tableCollection *mongo.Collection = database.OpenCollection(database.Client, "table")
 
+var SECRET_KEY string = os.Getenv("SECRET_KEY")
+
+// MinSecretKeyLength is the shortest SECRET_KEY accepted: tokens are signed
+// with HMAC-SHA256, whose key should be at least as long as its 32 byte hash.
+const MinSecretKeyLength = 32
+
+// RequireSecretKey checks that SECRET_KEY is set and long enough. Tokens
+// signed with an empty or short key can be forged, so the service must not
+// start without one.
+func RequireSecretKey() error {
+	return checkSecretKey(SECRET_KEY)
 }
 
-func ValidateToken() {
+func checkSecretKey(key string) error {
+	if key == "" {
+		return errors.New("SECRET_KEY is not set")
+	}
+	if len(key) < MinSecretKeyLength {
+		return fmt.Errorf("SECRET_KEY must be at least %d bytes long, it is %d", MinSecretKeyLength, len(key))
+	}
+	return nil
+}
+
+// GenerateTokens issues a signed access token and a longer lived refresh token for a user.
+func GenerateTokens(email string, uid string, role string, tokenVersion int) (signedToken string, signedRefreshToken string, err error) {
+	now := time.Now()
+
+	claims := &SignedDetails{
+		User_id:       uid,
+		Email:         email,
+		Role:          role,
+		Token_type:    AccessTokenType,
+		Token_version: tokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   uid,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenLifetime).Unix(),
+		},
+	}
+
+	refreshClaims := &SignedDetails{
+		User_id:    uid,
+		Token_type: RefreshTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   uid,
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(RefreshTokenLifetime).Unix(),
+		},
+	}
+
+	signedToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", "", err
+	}
+
+	signedRefreshToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", "", err
+	}
+
+	return signedToken, signedRefreshToken, nil
+}
+
+// GenerateTerminalToken issues a short lived access token that is only
+// accepted from the POS terminal it was issued for. No refresh token is
+// issued: staff sign in again with their PIN once it expires.
+func GenerateTerminalToken(email string, uid string, role string, tokenVersion int, terminalId string) (signedToken string, expiresAt time.Time, err error) {
+	now := time.Now()
+	expiresAt = now.Add(TerminalTokenLifetime)
+
+	claims := &SignedDetails{
+		User_id:       uid,
+		Email:         email,
+		Role:          role,
+		Token_type:    AccessTokenType,
+		Token_version: tokenVersion,
+		Terminal_id:   terminalId,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   uid,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	signedToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signedToken, expiresAt, nil
+}
+
+// GenerateTableSessionToken issues the token embedded in a table's QR code.
+// It lets guests order for that table only, until it expires or the session
+// is replaced on the table.
+func GenerateTableSessionToken(tableId string, sessionId string) (signedToken string, expiresAt time.Time, err error) {
+	now := time.Now()
+	expiresAt = now.Add(TableSessionTokenLifetime)
+
+	claims := &SignedDetails{
+		Table_id:   tableId,
+		Token_type: TableSessionTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   tableId,
+			Id:        sessionId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	signedToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signedToken, expiresAt, nil
+}
+
+// UpdateAllToken persists a freshly issued token pair on the user document.
+func UpdateAllToken(signedToken string, signedRefreshToken string, userId string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	matched, err := updateTokens(ctx, bson.M{"user_id": userId}, signedToken, signedRefreshToken)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return fmt.Errorf("user %s was not found", userId)
+	}
+	return nil
+}
+
+// updateTokens stores a token pair on the user matching filter and reports
+// whether one did.
+func updateTokens(ctx context.Context, filter bson.M, signedToken string, signedRefreshToken string) (bool, error) {
+	var updateObj primitive.D
+
+	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
+	updateObj = append(updateObj, bson.E{Key: "refresh_token", Value: signedRefreshToken})
+
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: Updated_at})
+
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{
+		{Key: "$set", Value: updateObj},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ValidateToken checks an access token and returns its claims.
+func ValidateToken(signedToken string) (claims *SignedDetails, err error) {
+	return parseToken(signedToken, AccessTokenType)
+}
+
+// ValidateTokenVersion checks that the user of an access token still exists
+// and has not revoked their tokens since it was issued.
+func ValidateTokenVersion(ctx context.Context, claims *SignedDetails) error {
+	var user struct {
+		Token_version int `bson:"token_version"`
+	}
+	err := userCollection.FindOne(ctx, bson.M{"user_id": claims.User_id}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrTokenRevoked
+		}
+		return err
+	}
+	if user.Token_version != claims.Token_version {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// ValidateRefreshToken checks a refresh token and returns its claims.
+func ValidateRefreshToken(signedRefreshToken string) (claims *SignedDetails, err error) {
+	return parseToken(signedRefreshToken, RefreshTokenType)
+}
+
+// ValidateTableSessionToken checks a table session token and that its session
+// is still the current one of the table.
+func ValidateTableSessionToken(signedToken string) (claims *SignedDetails, err error) {
+	claims, err = parseToken(signedToken, TableSessionTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var table struct {
+		Session_id *string `bson:"session_id"`
+	}
+	err = tableCollection.FindOne(ctx, bson.M{"table_id": claims.Table_id}).Decode(&table)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if table.Session_id == nil || *table.Session_id != claims.Id {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// RefreshTokens rotates the token pair of the user owning signedRefreshToken.
+// A refresh token can only be used once: it must match the one stored on the
+// user, which is replaced by the newly issued pair.
+func RefreshTokens(signedRefreshToken string) (signedToken string, newRefreshToken string, err error) {
+	claims, err := ValidateRefreshToken(signedRefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var user struct {
+		Email         *string `bson:"email"`
+		Role          *string `bson:"role"`
+		Refresh_token *string `bson:"refresh_token"`
+		Token_version int     `bson:"token_version"`
+	}
+	err = userCollection.FindOne(ctx, bson.M{"user_id": claims.User_id}).Decode(&user)
+	if err != nil {
+		return "", "", ErrTokenInvalid
+	}
+	if user.Refresh_token == nil || *user.Refresh_token != signedRefreshToken {
+		return "", "", ErrTokenInvalid
+	}
+
+	var email, role string
+	if user.Email != nil {
+		email = *user.Email
+	}
+	if user.Role != nil {
+		role = *user.Role
+	}
+
+	signedToken, newRefreshToken, err = GenerateTokens(email, claims.User_id, role, user.Token_version)
+	if err != nil {
+		return "", "", err
+	}
+	// The swap only happens while the presented refresh token is still the
+	// stored one, so of two concurrent refreshes with it only one succeeds.
+	matched, err := updateTokens(ctx, bson.M{"user_id": claims.User_id, "refresh_token": signedRefreshToken}, signedToken, newRefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if !matched {
+		return "", "", ErrTokenInvalid
+	}
+	return signedToken, newRefreshToken, nil
+}
+
+func parseToken(signedToken string, tokenType string) (*SignedDetails, error) {
+	token, err := jwt.ParseWithClaims(
+		signedToken,
+		&SignedDetails{},
+		func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, ErrTokenSignatureInvalid
+			}
+			return []byte(SECRET_KEY), nil
+		},
+	)
+
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) {
+			switch {
+			case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+				return nil, ErrTokenMalformed
+			case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+				return nil, ErrTokenExpired
+			case validationErr.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0:
+				return nil, ErrTokenSignatureInvalid
+			}
+		}
+		return nil, ErrTokenInvalid
+	}
+
+	claims, ok := token.Claims.(*SignedDetails)
+	if !ok || !token.Valid {
+		return nil, ErrTokenInvalid
+	}
+	if claims.Token_type != tokenType {
+		return nil, ErrTokenInvalid
+	}
 
+	return claims, nil
 }
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSecretKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "empty", key: "", wantErr: true},
+		{name: "too short", key: "secret", wantErr: true},
+		{name: "one byte short", key: strings.Repeat("k", MinSecretKeyLength-1), wantErr: true},
+		{name: "long enough", key: strings.Repeat("k", MinSecretKeyLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSecretKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("checkSecretKey() error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends messages through an SMTP relay.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if m.Host == "" {
+		return errors.New("SMTP_HOST is not configured")
+	}
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{msg.To}, format(m.From, msg))
+}
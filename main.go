@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
 	"restaurant-management/database"
+	helper "restaurant-management/helpers"
 	"restaurant-management/middleware"
 	"restaurant-management/routes"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -20,21 +24,43 @@ func main() {
 		port = "8000"
 	}
 
+	if err := helper.RequireSecretKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err := database.RequireTransactions(ctx)
+	if err == nil {
+		err = database.EnsureIndexes(ctx)
+	}
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	router := gin.New()
 
 	router.Use(gin.Logger())
 
 	routes.UserRoutes(router)
+	routes.PublicRoutes(router)
+	routes.GuestRoutes(router)
 	router.Use(middleware.Authentication())
 
 	routes.FoodRoutes(router)
 	routes.MenuRoutes(router)
+	routes.BundleRoutes(router)
 	routes.OrderRoutes(router)
 	routes.TableRoutes(router)
+	routes.ReservationRoutes(router)
+	routes.WaitlistRoutes(router)
 
 	routes.OrderItemRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.BillingRoutes(router)
 	routes.NoteRoutes(router)
+	routes.TerminalRoutes(router)
+	routes.KitchenRoutes(router)
 
 	router.Run(":" + port)
 
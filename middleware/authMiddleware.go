@@ -1,12 +1,110 @@
 package middleware
 
 import (
+	"errors"
+	"net/http"
+	helper "restaurant-management/helpers"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 )
 
 func Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		clientToken, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		claims, err := helper.ValidateToken(clientToken)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": tokenErrorMessage(err)})
+			return
+		}
+
+		if err := helper.ValidateTokenVersion(c.Request.Context(), claims); err != nil {
+			if !errors.Is(err, helper.ErrTokenRevoked) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": tokenErrorMessage(err)})
+			return
+		}
+
+		// A terminal token is only accepted with the secret key of its
+		// terminal, which must still be registered and active.
+		if claims.Terminal_id != "" {
+			if c.Request.Header.Get("X-Terminal-Id") != claims.Terminal_id {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token is bound to another terminal"})
+				return
+			}
+			if err := helper.VerifyTerminal(c.Request.Context(), claims.Terminal_id, c.Request.Header.Get("X-Terminal-Key")); err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "terminal key is missing or the terminal is no longer registered"})
+				return
+			}
+		}
+
+		c.Set("user_id", claims.User_id)
+		c.Set("email", claims.Email)
+		c.Set("role", claims.Role)
+		c.Set("terminal_id", claims.Terminal_id)
+		c.Next()
+	}
+
+}
+
+// TableSession authenticates guests with the table session token of a QR code.
+func TableSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		claims, err := helper.ValidateTableSessionToken(clientToken)
+		if err != nil {
+			msg := tokenErrorMessage(err)
+			if errors.Is(err, helper.ErrTokenInvalid) {
+				msg = "table session is no longer valid, scan the QR code again"
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		c.Set("table_id", claims.Table_id)
+		c.Set("session_id", claims.Id)
+		c.Next()
+	}
+}
 
+// bearerToken reads the token of the Authorization header, aborting the
+// request when there is none.
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.Request.Header.Get("Authorization")
+	if header == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no Authorization header provided"})
+		return "", false
 	}
 
+	scheme, clientToken, found := strings.Cut(header, " ")
+	clientToken = strings.TrimSpace(clientToken)
+	if !found || !strings.EqualFold(scheme, "Bearer") || clientToken == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header must be of the form 'Bearer <token>'"})
+		return "", false
+	}
+	return clientToken, true
+}
+
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, helper.ErrTokenExpired):
+		return "token has expired"
+	case errors.Is(err, helper.ErrTokenMalformed):
+		return "token is malformed"
+	case errors.Is(err, helper.ErrTokenSignatureInvalid):
+		return "token signature is invalid"
+	case errors.Is(err, helper.ErrTokenRevoked):
+		return "token has been revoked, sign in again"
+	}
+	return "token is invalid"
 }
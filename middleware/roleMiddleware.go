@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authorize only lets through requests whose authenticated role is one of
+// roles. It must be registered after Authentication.
+func Authorize(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "your role is not allowed to perform this action"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasRole reports whether the authenticated user holds one of roles.
+func HasRole(c *gin.Context, roles ...string) bool {
+	role := c.GetString("role")
+	if role == "" {
+		return false
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reason codes required to void, comp or refund.
+const (
+	ReasonEnteredInError    = "ENTERED_IN_ERROR"
+	ReasonCustomerChanged   = "CUSTOMER_CHANGED_MIND"
+	ReasonCustomerComplaint = "CUSTOMER_COMPLAINT"
+	ReasonQualityIssue      = "QUALITY_ISSUE"
+	ReasonLongWait          = "LONG_WAIT"
+	ReasonOvercharge        = "OVERCHARGE"
+	ReasonDuplicatePayment  = "DUPLICATE_PAYMENT"
+	ReasonManagerComp       = "MANAGER_COMP"
+	ReasonStaffMeal         = "STAFF_MEAL"
+	ReasonOther             = "OTHER"
+)
+
+// Adjustments of an order item: a voided item is taken off the order and
+// out of the kitchen, a comped one is still served but not charged.
+const (
+	AdjustmentVoid = "VOID"
+	AdjustmentComp = "COMP"
+)
+
+// Approval is the manager sign-off sent along a void, comp or refund asked
+// for by other staff: the manager's user id and PIN.
+type Approval struct {
+	User_id string `json:"user_id" validate:"required"`
+	Pin     string `json:"pin" validate:"required"`
+}
+
+// OrderItemAdjustment records a void or comp of some of the quantity of an
+// order item. Adjustments are only ever added, never changed, so they can be
+// reported on; Amount is the value taken off the bill in minor units.
+type OrderItemAdjustment struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Adjustment_id string             `json:"adjustment_id"`
+	Order_id      string             `json:"order_id"`
+	Order_item_id string             `json:"order_item_id"`
+	Type          string             `json:"type"`
+	Quantity      int                `json:"quantity"`
+	Amount        int64              `json:"amount"`
+	Reason        string             `json:"reason"`
+	Note          *string            `json:"note"`
+	Requested_by  string             `json:"requested_by"`
+	Approved_by   string             `json:"approved_by"`
+	Created_at    time.Time          `json:"created_at"`
+}
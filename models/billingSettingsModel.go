@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Tax modes: whether menu prices include taxes or have them added on top.
+const (
+	TaxModeExclusive = "EXCLUSIVE"
+	TaxModeInclusive = "INCLUSIVE"
+)
+
+// BillingSettingsId is the id of the single billing settings document.
+const BillingSettingsId = "billing"
+
+// BillingSettings are the restaurant wide rules invoices are computed with.
+// Cash_rounding is in minor units, e.g. 5 to round totals to 0.05.
+type BillingSettings struct {
+	ID                     string    `bson:"_id" json:"-"`
+	Currency               string    `json:"currency" validate:"required,len=3"`
+	Tax_mode               string    `json:"tax_mode" validate:"required,eq=EXCLUSIVE|eq=INCLUSIVE"`
+	Service_charge_percent float64   `json:"service_charge_percent" validate:"min=0,max=100"`
+	Cash_rounding          int64     `json:"cash_rounding" validate:"min=0,max=100"`
+	Updated_by             string    `json:"updated_by"`
+	Updated_at             time.Time `json:"updated_at"`
+}
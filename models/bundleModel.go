@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BundleSlot is one course of a bundle, filled by its default food unless the
+// guest picks one of the allowed substitutions.
+type BundleSlot struct {
+	Slot_id          string   `json:"slot_id"`
+	Name             *string  `json:"name" validate:"required,min=1,max=50"`
+	Default_food_id  *string  `json:"default_food_id" validate:"required"`
+	Allowed_food_ids []string `json:"allowed_food_ids"`
+}
+
+// Bundle is a combo or set meal sold at its own price, whatever its components cost.
+type Bundle struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         *string            `json:"name" validate:"required,min=2,max=100"`
+	Price        *float64           `json:"price" validate:"required"`
+	Bundle_image *string            `json:"bundle_image"`
+	Slots        []BundleSlot       `json:"slots" validate:"required,min=1,dive"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Bundle_id    string             `json:"bundle_id"`
+	Menu_id      *string            `json:"menu_id" validate:"required"`
+}
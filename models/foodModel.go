@@ -6,13 +6,44 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// FoodVariant is a size or other variant of a food, priced relative to the food.
+type FoodVariant struct {
+	Variant_id  string   `json:"variant_id"`
+	Name        *string  `json:"name" validate:"required,min=1,max=50"`
+	Price_delta *float64 `json:"price_delta" validate:"required"`
+}
+
+// FoodOption is one choice of an option group, e.g. "medium rare" or "extra cheese".
+type FoodOption struct {
+	Option_id   string   `json:"option_id"`
+	Name        *string  `json:"name" validate:"required,min=1,max=50"`
+	Price_delta *float64 `json:"price_delta"`
+}
+
+// OptionGroup is a set of modifiers for a food, of which between Min_select
+// and Max_select must be picked, e.g. "Steak doneness: pick 1".
+type OptionGroup struct {
+	Group_id   string       `json:"group_id"`
+	Name       *string      `json:"name" validate:"required,min=1,max=50"`
+	Min_select int          `json:"min_select" validate:"min=0"`
+	Max_select int          `json:"max_select" validate:"required,min=1,gtefield=Min_select"`
+	Options    []FoodOption `json:"options" validate:"required,min=1,dive"`
+}
+
+// Food is a dish on a menu. A food is sold out ("86'd") while Sold_out is set
+// or once its Portions_remaining counter, when it has one, reaches zero.
 type Food struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Name       *string            `json:"name" validate:"required,min=2,max=100"`
-	Price      *float64           `json:"price" validate:"required"`
-	Food_image *string            `json:"food_image" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Food_id    string             `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	ID                 primitive.ObjectID `bson:"_id"`
+	Name               *string            `json:"name" validate:"required,min=2,max=100"`
+	Price              *float64           `json:"price" validate:"required"`
+	Food_image         *string            `json:"food_image" validate:"required"`
+	Station            *string            `json:"station"`
+	Variants           []FoodVariant      `json:"variants" validate:"dive"`
+	Option_groups      []OptionGroup      `json:"option_groups" validate:"dive"`
+	Sold_out           bool               `json:"sold_out"`
+	Portions_remaining *int               `json:"portions_remaining" validate:"omitempty,min=0"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Food_id            string             `json:"food_id"`
+	Menu_id            *string            `json:"menu_id" validate:"required"`
 }
@@ -6,13 +6,87 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Operations and statuses of provider transactions, besides the approved and
+// declined answers of the provider.
+const (
+	ProviderOperationAuthorize = "AUTHORIZE"
+	ProviderOperationCapture   = "CAPTURE"
+	ProviderOperationRefund    = "REFUND"
+	ProviderOperationVoid      = "VOID"
+	ProviderStatusTimeout      = "TIMEOUT"
+	ProviderStatusError        = "ERROR"
+)
+
+// Payment statuses of an invoice. They follow the payments recorded against
+// it: an invoice is PAID once its balance reaches zero, and REFUNDED once
+// all that was paid on it was refunded.
+const (
+	InvoiceStatusPending       = "PENDING"
+	InvoiceStatusPartiallyPaid = "PARTIALLY_PAID"
+	InvoiceStatusPaid          = "PAID"
+	InvoiceStatusPartRefunded  = "PARTIALLY_REFUNDED"
+	InvoiceStatusRefunded      = "REFUNDED"
+)
+
+// Ways an order can be split between several invoices.
+const (
+	SplitByItems = "ITEMS"
+	SplitBySeats = "SEATS"
+	SplitEqually = "EQUAL"
+)
+
+// InvoiceTax is the amount charged for one tax rate on an invoice.
+type InvoiceTax struct {
+	Tax_rate_id string `json:"tax_rate_id"`
+	Name        string `json:"name"`
+	Rate_ppm    int64  `json:"rate_ppm"`
+	Taxable     int64  `json:"taxable"`
+	Amount      int64  `json:"amount"`
+}
+
+// ProviderTransaction records one call made to the payment provider for an
+// invoice, whatever its outcome: APPROVED, DECLINED, TIMEOUT or ERROR.
+type ProviderTransaction struct {
+	Provider       string    `json:"provider"`
+	Operation      string    `json:"operation"`
+	Transaction_id string    `json:"transaction_id"`
+	Status         string    `json:"status"`
+	Decline_code   string    `json:"decline_code"`
+	Amount         int64     `json:"amount"`
+	Payment_id     string    `json:"payment_id"`
+	Created_at     time.Time `json:"created_at"`
+}
+
+// Invoice is the bill of an order. Its breakdown is computed by the server
+// when the invoice is created or its discount or tip change; amounts are in
+// minor units of Currency. An order split between several payers has one
+// invoice per share, sharing a Split_id, each listing the items it pays for.
+// Payments are recorded separately and summed up in Amount_paid, refunds in
+// Amount_refunded. Every call made to the payment provider for card payments
+// is kept in Provider_transactions.
 type Invoice struct {
-	ID               primitive.ObjectID `bson:"_id"`
-	Invoice_id       *string            `json:"invoice_id"`
-	Order_id         *float64           `json:"order_id"`
-	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	Payment_status   *string            `json:"payment_status" validate:"required, eq=PENDING|eq=PAID"`
-	Payment_due_date time.Time          `json:"payment_due_date"`
-	Created_at       time.Time          `json:"created_at"`
-	Updated_at       time.Time          `json:"updated_at"`
+	ID                    primitive.ObjectID    `bson:"_id"`
+	Invoice_id            string                `json:"invoice_id"`
+	Order_id              string                `json:"order_id" validate:"required"`
+	Payment_method        *string               `json:"payment_method" validate:"omitempty,eq=CARD|eq=CASH"`
+	Payment_status        *string               `json:"payment_status"`
+	Payment_due_date      time.Time             `json:"payment_due_date"`
+	Currency              string                `json:"currency"`
+	Tax_mode              string                `json:"tax_mode"`
+	Subtotal              int64                 `json:"subtotal"`
+	Discount              int64                 `json:"discount" validate:"min=0"`
+	Service_charge        int64                 `json:"service_charge"`
+	Taxes                 []InvoiceTax          `json:"taxes"`
+	Tax_total             int64                 `json:"tax_total"`
+	Tip                   int64                 `json:"tip" validate:"min=0"`
+	Rounding              int64                 `json:"rounding"`
+	Total                 int64                 `json:"total"`
+	Amount_paid           int64                 `json:"amount_paid"`
+	Amount_refunded       int64                 `json:"amount_refunded"`
+	Provider_transactions []ProviderTransaction `json:"provider_transactions"`
+	Split_id              *string               `json:"split_id"`
+	Split_mode            *string               `json:"split_mode"`
+	Order_item_ids        []string              `json:"order_item_ids"`
+	Created_at            time.Time             `json:"created_at"`
+	Updated_at            time.Time             `json:"updated_at"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of kitchen events.
+const (
+	KitchenEventItemAdded     = "ITEM_ADDED"
+	KitchenEventItemUpdated   = "ITEM_UPDATED"
+	KitchenEventItemCancelled = "ITEM_CANCELLED"
+)
+
+// KitchenEvent is one entry of the kitchen display feed. Event_id increases
+// monotonically so a display can resume the feed after reconnecting.
+type KitchenEvent struct {
+	ID         primitive.ObjectID `bson:"_id" json:"-"`
+	Event_id   int64              `json:"event_id"`
+	Type       string             `json:"type"`
+	Station    *string            `json:"station"`
+	Order_id   string             `json:"order_id"`
+	Table_id   *string            `json:"table_id"`
+	Food_name  *string            `json:"food_name"`
+	Order_item OrderItem          `json:"order_item"`
+	Created_at time.Time          `json:"created_at"`
+}
@@ -6,14 +6,25 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// MenuSchedule is a recurring window in which a menu is served, e.g. breakfast
+// from 07:00 to 11:00 on weekdays. Times are wall clock times in the
+// restaurant's timezone; a window ending before it starts runs past midnight
+// and belongs to the day it starts on.
+type MenuSchedule struct {
+	Days  []string `json:"days" validate:"required,min=1,dive,oneof=MON TUE WED THU FRI SAT SUN"`
+	Start string   `json:"start" validate:"required,datetime=15:04"`
+	End   string   `json:"end" validate:"required,datetime=15:04,nefield=Start"`
+}
+
 type Menu struct {
 	ID         primitive.ObjectID `bson:"_id"`
 	Name       string             `json:"name" validate:"required"`
 	Category   string             `json:"category" validate:"required"`
 	Start_Date *time.Time         `json:"start_date"`
 	End_Date   *time.Time         `json:"end_date"`
+	Schedules  []MenuSchedule     `json:"schedules" validate:"dive"`
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
 	Food_id    *string            `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	Menu_id    string             `json:"menu_id"`
 }
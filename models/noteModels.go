@@ -6,11 +6,18 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Note is a free text note, optionally attached to an order, a table, a food
+// or a shift handover.
 type Note struct {
 	ID         primitive.ObjectID `bson:"_id"`
-	Text       string             `json:"text"`
-	Title      string             `json:"title"`
+	Text       string             `json:"text" validate:"required,max=2000"`
+	Title      string             `json:"title" validate:"max=200"`
+	Order_id   *string            `json:"order_id"`
+	Table_id   *string            `json:"table_id"`
+	Food_id    *string            `json:"food_id"`
+	Shift      *string            `json:"shift" validate:"omitempty,max=50"`
+	Created_by string             `json:"created_by"`
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
 	Note_id    string             `json:"note_id"`
-}
\ No newline at end of file
+}
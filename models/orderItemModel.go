@@ -6,13 +6,73 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Preparation statuses of an order item once its order has been fired.
+const (
+	ItemStatusQueued    = "QUEUED"
+	ItemStatusCooking   = "COOKING"
+	ItemStatusReady     = "READY"
+	ItemStatusDelivered = "DELIVERED"
+)
+
+// ItemStatusTransitions lists, for every status, the statuses an order item may move to next.
+var ItemStatusTransitions = map[string][]string{
+	ItemStatusQueued:    {ItemStatusCooking, ItemStatusReady},
+	ItemStatusCooking:   {ItemStatusReady},
+	ItemStatusReady:     {ItemStatusDelivered},
+	ItemStatusDelivered: {},
+}
+
+// OrderItemModifier is an option chosen for an order item, with the name and
+// price it had when ordered.
+type OrderItemModifier struct {
+	Group_id   string   `json:"group_id" validate:"required"`
+	Option_id  string   `json:"option_id" validate:"required"`
+	Group_name *string  `json:"group_name"`
+	Name       *string  `json:"name"`
+	Price      *float64 `json:"price"`
+}
+
+// BundleChoice picks the food, and its modifiers, for one slot of a bundle.
+type BundleChoice struct {
+	Slot_id   string              `json:"slot_id" validate:"required"`
+	Food_id   string              `json:"food_id" validate:"required"`
+	Modifiers []OrderItemModifier `json:"modifiers" validate:"dive"`
+}
+
+// OrderItem is one line of an order. A bundle is ordered as a billing line
+// carrying Bundle_id plus one component per slot, linked back to it through
+// Parent_order_item_id, which is what the kitchen prepares. Items ordered by
+// guests from a table QR code await confirmation by staff before they reach
+// the kitchen or the bill. Seat is the seat number of the guest it is for,
+// used to split the bill by seat. Voided_quantity and Comped_quantity are not
+// charged; an item voided entirely is Voided and off to the kitchen.
 type OrderItem struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	Quantity      *string            `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	Unit_price    *float64           `json:"unit_price" validate:"required"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	Order_id      string             `json:"order_id" validate:"required"`
-	Order_item_id string             `json:"order_item_id"`
-	Food_id       *string            `json:"food_id" validate:"required"`
+	ID                    primitive.ObjectID  `bson:"_id"`
+	Quantity              *int                `json:"quantity" validate:"required,min=1"`
+	Seat                  *int                `json:"seat" validate:"omitempty,min=1"`
+	Variant_id            *string             `json:"variant_id"`
+	Variant_name          *string             `json:"variant_name"`
+	Modifiers             []OrderItemModifier `json:"modifiers" validate:"dive"`
+	Unit_price            *float64            `json:"unit_price"`
+	Item_status           *string             `json:"item_status"`
+	Queued_at             *time.Time          `json:"queued_at"`
+	Cooking_at            *time.Time          `json:"cooking_at"`
+	Ready_at              *time.Time          `json:"ready_at"`
+	Delivered_at          *time.Time          `json:"delivered_at"`
+	Prep_seconds          *int64              `json:"prep_seconds"`
+	Created_at            time.Time           `json:"created_at"`
+	Updated_at            time.Time           `json:"updated_at"`
+	Order_id              string              `json:"order_id" validate:"required"`
+	Order_item_id         string              `json:"order_item_id"`
+	Food_id               *string             `json:"food_id" validate:"required_without=Bundle_id"`
+	Bundle_id             *string             `json:"bundle_id"`
+	Bundle_choices        []BundleChoice      `json:"bundle_choices" validate:"dive"`
+	Parent_order_item_id  *string             `json:"parent_order_item_id"`
+	Awaiting_confirmation bool                `json:"awaiting_confirmation"`
+	Guest_session_id      *string             `json:"guest_session_id"`
+	Confirmed_by          *string             `json:"confirmed_by"`
+	Confirmed_at          *time.Time          `json:"confirmed_at"`
+	Voided_quantity       int                 `json:"voided_quantity" validate:"min=0"`
+	Comped_quantity       int                 `json:"comped_quantity" validate:"min=0"`
+	Voided                bool                `json:"voided"`
 }
@@ -6,11 +6,48 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Order lifecycle statuses.
+const (
+	OrderStatusOpen      = "OPEN"
+	OrderStatusFired     = "FIRED"
+	OrderStatusReady     = "READY"
+	OrderStatusServed    = "SERVED"
+	OrderStatusBilled    = "BILLED"
+	OrderStatusClosed    = "CLOSED"
+	OrderStatusCancelled = "CANCELLED"
+	OrderStatusVoid      = "VOID"
+)
+
+// OrderStatusTransitions lists, for every status, the statuses an order may move to next.
+var OrderStatusTransitions = map[string][]string{
+	OrderStatusOpen:      {OrderStatusFired, OrderStatusCancelled},
+	OrderStatusFired:     {OrderStatusReady, OrderStatusServed, OrderStatusVoid},
+	OrderStatusReady:     {OrderStatusServed, OrderStatusVoid},
+	OrderStatusServed:    {OrderStatusBilled, OrderStatusVoid},
+	OrderStatusBilled:    {OrderStatusClosed},
+	OrderStatusClosed:    {},
+	OrderStatusCancelled: {},
+	OrderStatusVoid:      {},
+}
+
+type OrderStatusChange struct {
+	Status     string    `json:"status"`
+	Changed_by string    `json:"changed_by"`
+	Changed_at time.Time `json:"changed_at"`
+}
+
+// Order is what a table ordered. An order merged into another one is
+// cancelled and points to it through Merged_into; an order split off another
+// one points back to it through Split_from.
 type Order struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Order_Date time.Time          `json:"order_date"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Order_id   string             `json:"order_id"`
-	Table_id   *string            `json:"table_id"  validate:"required"`
+	ID             primitive.ObjectID  `bson:"_id"`
+	Order_Date     time.Time           `json:"order_date"`
+	Order_status   *string             `json:"order_status"`
+	Status_history []OrderStatusChange `json:"status_history"`
+	Created_at     time.Time           `json:"created_at"`
+	Updated_at     time.Time           `json:"updated_at"`
+	Order_id       string              `json:"order_id"`
+	Table_id       *string             `json:"table_id"  validate:"required"`
+	Merged_into    *string             `json:"merged_into"`
+	Split_from     *string             `json:"split_from"`
 }
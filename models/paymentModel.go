@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of payment entries: a refund is a reversal of an earlier payment,
+// with a negative amount.
+const (
+	PaymentKindPayment = "PAYMENT"
+	PaymentKindRefund  = "REFUND"
+)
+
+// Tenders an invoice can be paid with.
+const (
+	TenderCash     = "CASH"
+	TenderCard     = "CARD"
+	TenderVoucher  = "VOUCHER"
+	TenderGiftCard = "GIFT_CARD"
+)
+
+// Payment is one tender paid against an invoice. Amount is what it settles
+// of the invoice; for cash, Tendered is what the guest handed over and Change
+// what they got back. Amounts are in minor units of the invoice's currency.
+// Payments are never changed: a refund is recorded as a new entry pointing
+// back to the payment it reverses through Refund_of. Card payments go through
+// the payment provider with Card_token, which is never stored, and keep the
+// provider's transaction id.
+type Payment struct {
+	ID                      primitive.ObjectID `bson:"_id"`
+	Payment_id              string             `json:"payment_id"`
+	Invoice_id              string             `json:"invoice_id"`
+	Order_id                string             `json:"order_id"`
+	Tender                  string             `json:"tender" validate:"required,eq=CASH|eq=CARD|eq=VOUCHER|eq=GIFT_CARD"`
+	Amount                  int64              `json:"amount" validate:"min=0"`
+	Tendered                int64              `json:"tendered" validate:"min=0"`
+	Change                  int64              `json:"change"`
+	Reference               *string            `json:"reference" validate:"required_if=Tender VOUCHER,required_if=Tender GIFT_CARD,omitempty,max=100"`
+	Card_token              *string            `json:"card_token" bson:"-" validate:"required_if=Tender CARD"`
+	Provider                *string            `json:"provider"`
+	Provider_transaction_id *string            `json:"provider_transaction_id"`
+	Kind                    string             `json:"kind"`
+	Refund_of               *string            `json:"refund_of"`
+	Reason                  *string            `json:"reason"`
+	Note                    *string            `json:"note"`
+	Approved_by             *string            `json:"approved_by"`
+	Created_by              string             `json:"created_by"`
+	Created_at              time.Time          `json:"created_at"`
+}
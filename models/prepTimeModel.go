@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PrepTime records how long the kitchen took to prepare one order item.
+type PrepTime struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Food_id       *string            `json:"food_id"`
+	Order_item_id string             `json:"order_item_id"`
+	Station       *string            `json:"station"`
+	Wait_seconds  int64              `json:"wait_seconds"`
+	Prep_seconds  int64              `json:"prep_seconds"`
+	Total_seconds int64              `json:"total_seconds"`
+	Ready_at      time.Time          `json:"ready_at"`
+	Created_at    time.Time          `json:"created_at"`
+	Prep_time_id  string             `json:"prep_time_id"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reservation statuses.
+const (
+	ReservationStatusBooked    = "BOOKED"
+	ReservationStatusSeated    = "SEATED"
+	ReservationStatusCancelled = "CANCELLED"
+	ReservationStatusNoShow    = "NO_SHOW"
+)
+
+// DefaultReservationMinutes is how long a table is held for a booking that
+// does not say otherwise.
+const DefaultReservationMinutes = 90
+
+// Reservation is a booking of a table for a party. It holds its table from
+// Reserved_for until Ends_at.
+type Reservation struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Party_size         *int               `json:"party_size" validate:"required,min=1"`
+	Reserved_for       *time.Time         `json:"reserved_for" validate:"required"`
+	Duration_minutes   int                `json:"duration_minutes" validate:"omitempty,min=15,max=480"`
+	Ends_at            *time.Time         `json:"ends_at"`
+	Contact_name       *string            `json:"contact_name" validate:"required,min=2,max=100"`
+	Contact_phone      *string            `json:"contact_phone" validate:"required"`
+	Contact_email      *string            `json:"contact_email" validate:"omitempty,email"`
+	Notes              *string            `json:"notes" validate:"omitempty,max=500"`
+	Table_id           *string            `json:"table_id"`
+	Reservation_status *string            `json:"reservation_status"`
+	Order_id           *string            `json:"order_id"`
+	Seated_at          *time.Time         `json:"seated_at"`
+	Created_by         string             `json:"created_by"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Reservation_id     string             `json:"reservation_id"`
+}
@@ -6,11 +6,35 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Table statuses shown on the floor plan.
+const (
+	TableStatusFree          = "FREE"
+	TableStatusSeated        = "SEATED"
+	TableStatusOrdered       = "ORDERED"
+	TableStatusBilled        = "BILLED"
+	TableStatusNeedsCleaning = "NEEDS_CLEANING"
+	TableStatusReserved      = "RESERVED"
+)
+
+// Table is a table of the dining room. Number_of_guests is the party
+// currently seated, Seat_capacity the number of seats; Pos_x and Pos_y place
+// it on the floor plan of its section.
 type Table struct {
-	ID               primitive.ObjectID `bson:"_id"`
-	Number_of_guests *int               `json:"number_of_guests" validate:"required"`
-	Table_number     *int               `json:"table_number" validate:"required"`
-	Created_at       time.Time          `json:"created_at"`
-	Updated_at       time.Time          `json:"updated_at"`
-	Table_id         *string            `json:"table_id"`
+	ID                 primitive.ObjectID `bson:"_id"`
+	Number_of_guests   *int               `json:"number_of_guests" validate:"required,min=0"`
+	Table_number       *int               `json:"table_number" validate:"required"`
+	Table_status       *string            `json:"table_status" validate:"omitempty,eq=FREE|eq=SEATED|eq=ORDERED|eq=BILLED|eq=NEEDS_CLEANING|eq=RESERVED"`
+	Section            *string            `json:"section" validate:"omitempty,max=50"`
+	Seat_capacity      *int               `json:"seat_capacity" validate:"omitempty,min=1"`
+	Pos_x              *float64           `json:"pos_x"`
+	Pos_y              *float64           `json:"pos_y"`
+	Seated_at          *time.Time         `json:"seated_at"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Table_id           string             `json:"table_id"`
+	Session_id         *string            `json:"-"`
+	Session_expires_at *time.Time         `json:"session_expires_at"`
+	// Held_reservation_id is the booking a RESERVED table is held for, when
+	// the hold was set from the reservations rather than by hand.
+	Held_reservation_id *string `json:"held_reservation_id"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TaxRate is a tax charged on the foods of a menu category. A rate without
+// a category applies to every category that has no rate of its own. Several
+// rates may apply to the same category, e.g. a state and a city tax.
+type TaxRate struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        *string            `json:"name" validate:"required,min=1,max=50"`
+	Category    *string            `json:"category"`
+	Rate        *float64           `json:"rate" validate:"required,min=0,max=100"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+	Tax_rate_id string             `json:"tax_rate_id"`
+}
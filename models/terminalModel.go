@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Terminal is a shared POS device that staff can sign in to with their PIN.
+type Terminal struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         *string            `json:"name" validate:"required,min=2,max=100"`
+	Terminal_key *string            `json:"terminal_key"`
+	Active       *bool              `json:"active"`
+	Created_by   string             `json:"created_by"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Terminal_id  string             `json:"terminal_id"`
+}
@@ -6,17 +6,32 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Staff roles a user can hold.
+const (
+	RoleOwner   = "OWNER"
+	RoleManager = "MANAGER"
+	RoleWaiter  = "WAITER"
+	RoleCashier = "CASHIER"
+	RoleKitchen = "KITCHEN"
+)
+
 type User struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	First_name    *string            `json:"first_name" validate:"required,min=2,max=100"`
-	Last_name     *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password      *string            `json:"Password" validate:"required,min=6"`
-	Email         *string            `json:"email" validate:"email,required"`
-	Avatar        *string            `json:"avatar"`
-	Phone         *string            `json:"phone" validate:"required"`
-	Token         *string            `json:"token"`
-	Refresh_Token *string            `json:"refresh_token"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	User_id       string             `json:"user_id"`
-}
\ No newline at end of file
+	ID               primitive.ObjectID `bson:"_id"`
+	First_name       *string            `json:"first_name" validate:"required,min=2,max=100"`
+	Last_name        *string            `json:"last_name" validate:"required,min=2,max=100"`
+	Password         *string            `json:"Password" validate:"required,min=6"`
+	Email            *string            `json:"email" validate:"email,required"`
+	Email_verified   bool               `json:"email_verified"`
+	Avatar           *string            `json:"avatar"`
+	Phone            *string            `json:"phone" validate:"required"`
+	Role             *string            `json:"role" validate:"omitempty,eq=OWNER|eq=MANAGER|eq=WAITER|eq=CASHIER|eq=KITCHEN"`
+	Pin              *string            `json:"pin"`
+	Pin_attempts     int                `json:"pin_attempts"`
+	Pin_locked_until *time.Time         `json:"pin_locked_until"`
+	Token            *string            `json:"token"`
+	Refresh_Token    *string            `json:"refresh_token"`
+	Token_version    int                `json:"-"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+	User_id          string             `json:"user_id"`
+}
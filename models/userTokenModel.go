@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes a UserToken can be issued for.
+const (
+	TokenPurposePasswordReset     = "PASSWORD_RESET"
+	TokenPurposeEmailVerification = "EMAIL_VERIFICATION"
+)
+
+// UserToken is a one-time token mailed to a user. Only its SHA-256 hash is stored.
+type UserToken struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Token_hash    string             `json:"token_hash"`
+	User_id       string             `json:"user_id"`
+	Purpose       string             `json:"purpose"`
+	Expires_at    time.Time          `json:"expires_at"`
+	Used_at       *time.Time         `json:"used_at"`
+	Created_at    time.Time          `json:"created_at"`
+	User_token_id string             `json:"user_token_id"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Waitlist statuses.
+const (
+	WaitlistStatusWaiting = "WAITING"
+	WaitlistStatusSeated  = "SEATED"
+	WaitlistStatusLeft    = "LEFT"
+)
+
+// WaitlistEntry is a walk-in party waiting for a table, with the wait they
+// were quoted when they joined the list.
+type WaitlistEntry struct {
+	ID                  primitive.ObjectID `bson:"_id"`
+	Party_size          *int               `json:"party_size" validate:"required,min=1"`
+	Contact_name        *string            `json:"contact_name" validate:"required,min=2,max=100"`
+	Contact_phone       *string            `json:"contact_phone"`
+	Notes               *string            `json:"notes" validate:"omitempty,max=500"`
+	Quoted_wait_minutes int                `json:"quoted_wait_minutes"`
+	Waitlist_status     *string            `json:"waitlist_status"`
+	Table_id            *string            `json:"table_id"`
+	Order_id            *string            `json:"order_id"`
+	Seated_at           *time.Time         `json:"seated_at"`
+	Created_at          time.Time          `json:"created_at"`
+	Updated_at          time.Time          `json:"updated_at"`
+	Waitlist_entry_id   string             `json:"waitlist_entry_id"`
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func BillingRoutes(incommingRoutes *gin.Engine) {
+	incommingRoutes.GET("/billing/settings", allow(billing), controller.GetBillingSettings())
+	incommingRoutes.PUT("/billing/settings", allow(managers), controller.UpdateBillingSettings())
+	incommingRoutes.GET("/taxRates", allow(billing), controller.GetTaxRates())
+	incommingRoutes.POST("/taxRates", allow(managers), controller.CreateTaxRate())
+	incommingRoutes.PATCH("/taxRates/:tax_rate_id", allow(managers), controller.UpdateTaxRate())
+	incommingRoutes.DELETE("/taxRates/:tax_rate_id", allow(managers), controller.DeleteTaxRate())
+
+}
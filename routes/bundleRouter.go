@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func BundleRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/bundles", allow(allStaff), controller.GetBundles())
+	incomingRoutes.GET("/bundles/:bundle_id", allow(allStaff), controller.GetBundle())
+	incomingRoutes.POST("/bundles", allow(managers), controller.CreateBundle())
+	incomingRoutes.PATCH("/bundles/:bundle_id", allow(managers), controller.UpdateBundle())
+
+}
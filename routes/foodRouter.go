@@ -7,9 +7,12 @@ import (
 )
 
 func FoodRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/foods", controller.GetFoods())
-	incomingRoutes.GET("/foods/:food_id", controller.GetFood())
-	incomingRoutes.POST("/foods", controller.CreateFood())
-	incomingRoutes.PATCH("/foods/:food_id", controller.UpdateFood())
+	incomingRoutes.GET("/foods", allow(allStaff), controller.GetFoods())
+	incomingRoutes.GET("/foods/available", allow(allStaff), controller.GetAvailableFoods())
+	incomingRoutes.GET("/foods/:food_id", allow(allStaff), controller.GetFood())
+	incomingRoutes.POST("/foods", allow(managers), controller.CreateFood())
+	incomingRoutes.PATCH("/foods/:food_id", allow(managers), controller.UpdateFood())
+	incomingRoutes.POST("/foods/:food_id/86", allow(kitchen), controller.MarkFoodSoldOut())
+	incomingRoutes.DELETE("/foods/:food_id/86", allow(kitchen), controller.ClearFoodSoldOut())
 
 }
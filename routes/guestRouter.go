@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+	"restaurant-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuestRoutes are called from a table's QR code with its table session token
+// and must be registered before the staff authentication middleware.
+func GuestRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/guest/order", middleware.TableSession(), controller.GetGuestOrder())
+	incomingRoutes.POST("/guest/orderItems", middleware.TableSession(), controller.CreateGuestOrderItems())
+
+}
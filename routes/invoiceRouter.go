@@ -7,9 +7,13 @@ import (
 )
 
 func InvoiceRoutes(incommingRoutes *gin.Engine) {
-	incommingRoutes.GET("/invoices", controller.GetInvoices())
-	incommingRoutes.GET("/invoices/:invoice_id", controller.GetInvoice())
-	incommingRoutes.POST("/invoices", controller.CreateInvoice())
-	incommingRoutes.PATCH("/invoices/:invoice_id", controller.UpdateInvoice())
+	incommingRoutes.GET("/invoices", allow(billing), controller.GetInvoices())
+	incommingRoutes.GET("/invoices/:invoice_id", allow(billing), controller.GetInvoice())
+	incommingRoutes.POST("/invoices", allow(billing), controller.CreateInvoice())
+	incommingRoutes.POST("/invoices/split", allow(billing), controller.SplitInvoices())
+	incommingRoutes.PATCH("/invoices/:invoice_id", allow(billing), controller.UpdateInvoice())
+	incommingRoutes.GET("/invoices/:invoice_id/payments", allow(billing), controller.GetPayments())
+	incommingRoutes.POST("/invoices/:invoice_id/payments", allow(cashiers), controller.CreatePayment())
+	incommingRoutes.POST("/invoices/:invoice_id/payments/:payment_id/refund", allow(cashiers), controller.RefundPayment())
 
 }
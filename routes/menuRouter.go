@@ -2,14 +2,15 @@ package routes
 
 import (
 	controller "restaurant-management/controllers"
+
 	"github.com/gin-gonic/gin"
 )
 
-func MenuRoutes(incommingRoutes *gin.Engine){
+func MenuRoutes(incommingRoutes *gin.Engine) {
 
-	incommingRoutes.GET("/menus",controller.GetMenus())
-	incommingRoutes.GET("/menus/:menu_id",controller.GetMenu())
-	incommingRoutes.GET("/menus",controller.CreateMenu())
-	incommingRoutes.GET("/menus/:menu_id",controller.UpdateMenu())
+	incommingRoutes.GET("/menus", allow(allStaff), controller.GetMenus())
+	incommingRoutes.GET("/menus/available", allow(allStaff), controller.GetAvailableMenus())
+	incommingRoutes.GET("/menus/:menu_id", allow(allStaff), controller.GetMenu())
+	incommingRoutes.POST("/menus", allow(managers), controller.CreateMenu())
+	incommingRoutes.PATCH("/menus/:menu_id", allow(managers), controller.UpdateMenu())
 }
-
@@ -7,9 +7,9 @@ import (
 )
 
 func NoteRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/note", controller.GetNotes())
-	incomingRoutes.GET("/notes/:note_id", controller.GetNote())
-	incomingRoutes.POST("/notes", controller.CreateNote())
-	incomingRoutes.PATCH("/notes/:note_id", controller.UpdateNote())
+	incomingRoutes.GET("/notes", allow(allStaff), controller.GetNotes())
+	incomingRoutes.GET("/notes/:note_id", allow(allStaff), controller.GetNote())
+	incomingRoutes.POST("/notes", allow(allStaff), controller.CreateNote())
+	incomingRoutes.PATCH("/notes/:note_id", allow(allStaff), controller.UpdateNote())
 
 }
@@ -7,10 +7,13 @@ import (
 )
 
 func OrderItemRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/orderItems", controller.GetOrderItems())
-	incomingRoutes.GET("/orderItems/:orderItem_id", controller.GetOrderItem())
-	incomingRoutes.GET("/orderItems-order/:orderItem_id", controller.GetOrderItemByOrder())
-	incomingRoutes.POST("/orderItems", controller.CreateOrderItem())
-	incomingRoutes.PATCH("/orderItems/:orderItem_id", controller.UpdateOrderItem())
+	incomingRoutes.GET("/orderItems", allow(allStaff), controller.GetOrderItems())
+	incomingRoutes.GET("/orderItems/:orderItem_id", allow(allStaff), controller.GetOrderItem())
+	incomingRoutes.GET("/orderItems-order/:orderItem_id", allow(allStaff), controller.GetOrderItemByOrder())
+	incomingRoutes.POST("/orderItems", allow(floorStaff), controller.CreateOrderItem())
+	incomingRoutes.PATCH("/orderItems/:orderItem_id", allow(floorStaff), controller.UpdateOrderItem())
+	incomingRoutes.PATCH("/orderItems/:orderItem_id/status", allow(allStaff), controller.UpdateOrderItemStatus())
+	incomingRoutes.POST("/orderItems/:orderItem_id/adjustments", allow(billing), controller.AdjustOrderItem())
+	incomingRoutes.GET("/adjustments", allow(managers), controller.GetAdjustments())
 
 }
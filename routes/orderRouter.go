@@ -7,9 +7,15 @@ import (
 )
 
 func OrderRoutes(incommingRoutes *gin.Engine) {
-	incommingRoutes.GET("/orders", controller.GetOrders())
-	incommingRoutes.GET("/orders/:order_id", controller.GetOrder())
-	incommingRoutes.POST("/orders", controller.CreateOrder())
-	incommingRoutes.PATCH("/orders/:order_id", controller.UpdateOrder())
+	incommingRoutes.GET("/orders", allow(allStaff), controller.GetOrders())
+	incommingRoutes.GET("/orders/:order_id", allow(allStaff), controller.GetOrder())
+	incommingRoutes.POST("/orders", allow(floorStaff), controller.CreateOrder())
+	incommingRoutes.PATCH("/orders/:order_id", allow(floorStaff), controller.UpdateOrder())
+	incommingRoutes.PATCH("/orders/:order_id/status", allow(billing), controller.UpdateOrderStatus())
+	incommingRoutes.POST("/orders/:order_id/transfer", allow(floorStaff), controller.TransferOrder())
+	incommingRoutes.POST("/orders/:order_id/merge", allow(floorStaff), controller.MergeOrders())
+	incommingRoutes.POST("/orders/:order_id/split", allow(floorStaff), controller.SplitOrder())
+	incommingRoutes.POST("/orders/:order_id/items/confirm", allow(floorStaff), controller.ConfirmOrderItems())
+	incommingRoutes.POST("/orders/:order_id/items/reject", allow(floorStaff), controller.RejectOrderItems())
 
 }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"restaurant-management/middleware"
+	"restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role sets used by the routers to declare who may call each route.
+var (
+	allStaff   = []string{models.RoleOwner, models.RoleManager, models.RoleWaiter, models.RoleCashier, models.RoleKitchen}
+	managers   = []string{models.RoleOwner, models.RoleManager}
+	floorStaff = []string{models.RoleOwner, models.RoleManager, models.RoleWaiter}
+	billing    = []string{models.RoleOwner, models.RoleManager, models.RoleWaiter, models.RoleCashier}
+	cashiers   = []string{models.RoleOwner, models.RoleManager, models.RoleCashier}
+	kitchen    = []string{models.RoleOwner, models.RoleManager, models.RoleKitchen}
+)
+
+func allow(roles []string) gin.HandlerFunc {
+	return middleware.Authorize(roles...)
+}
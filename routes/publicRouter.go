@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PublicRoutes are served without credentials and must be registered before
+// the authentication middleware.
+func PublicRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/public/menu", controller.GetPublicMenu())
+
+}
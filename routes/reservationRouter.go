@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ReservationRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/reservations", allow(allStaff), controller.GetReservations())
+	incomingRoutes.GET("/reservations/suggestions", allow(floorStaff), controller.SuggestTables())
+	incomingRoutes.GET("/reservations/:reservation_id", allow(allStaff), controller.GetReservation())
+	incomingRoutes.POST("/reservations", allow(floorStaff), controller.CreateReservation())
+	incomingRoutes.PATCH("/reservations/:reservation_id", allow(floorStaff), controller.UpdateReservation())
+	incomingRoutes.POST("/reservations/:reservation_id/seat", allow(floorStaff), controller.SeatReservation())
+
+}
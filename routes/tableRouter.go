@@ -7,9 +7,13 @@ import (
 )
 
 func TableRoutes(incommingRoutes *gin.Engine) {
-	incommingRoutes.GET("/tables", controller.GetTables())
-	incommingRoutes.GET("/tables/:table_id", controller.GetTable())
-	incommingRoutes.POST("/tables", controller.CreateTable())
-	incommingRoutes.PATCH("/tables/:table_id", controller.UpdateTable())
+	incommingRoutes.GET("/tables", allow(allStaff), controller.GetTables())
+	incommingRoutes.GET("/tables/floor", allow(allStaff), controller.GetFloor())
+	incommingRoutes.GET("/tables/:table_id", allow(allStaff), controller.GetTable())
+	incommingRoutes.POST("/tables", allow(managers), controller.CreateTable())
+	incommingRoutes.PATCH("/tables/:table_id", allow(floorStaff), controller.UpdateTable())
+	incommingRoutes.PATCH("/tables/:table_id/status", allow(floorStaff), controller.UpdateTableStatus())
+	incommingRoutes.POST("/tables/:table_id/session", allow(floorStaff), controller.StartTableSession())
+	incommingRoutes.DELETE("/tables/:table_id/session", allow(floorStaff), controller.EndTableSession())
 
 }
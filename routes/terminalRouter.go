@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TerminalRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/terminals", allow(managers), controller.GetTerminals())
+	incomingRoutes.POST("/terminals", allow(managers), controller.CreateTerminal())
+	incomingRoutes.PATCH("/terminals/:terminal_id", allow(managers), controller.UpdateTerminal())
+
+}
@@ -1,15 +1,25 @@
 package routes
 
-import(
-	"github.com/gin-gonic/gin"
+import (
 	controller "restaurant-management/controllers"
+	"restaurant-management/middleware"
+
+	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(incomingRoutes *gin.Engine){
-	incomingRoutes.GET("/users",controller.GetUsers())
-	incomingRoutes.GET("/users:user_id",controller.GetUser())
-	incomingRoutes.POST("/users/signup",controller.SignUp())
-	incomingRoutes.POST("/users/login",controller.Login())
+func UserRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/users", middleware.Authentication(), allow(managers), controller.GetUsers())
+	incomingRoutes.GET("/users/:user_id", middleware.Authentication(), allow(managers), controller.GetUser())
+	incomingRoutes.POST("/users", middleware.Authentication(), allow(managers), controller.CreateUser())
+	incomingRoutes.PATCH("/users/:user_id/role", middleware.Authentication(), allow(managers), controller.UpdateUserRole())
+	incomingRoutes.PATCH("/users/:user_id/pin", middleware.Authentication(), allow(allStaff), controller.UpdateUserPin())
+	incomingRoutes.POST("/users/signup", controller.SignUp())
+	incomingRoutes.POST("/users/login", controller.Login())
+	incomingRoutes.POST("/users/refresh", controller.RefreshToken())
+	incomingRoutes.POST("/users/pin-login", controller.PinLogin())
+	incomingRoutes.POST("/users/password-reset/request", controller.RequestPasswordReset())
+	incomingRoutes.POST("/users/password-reset/confirm", controller.ResetPassword())
+	incomingRoutes.POST("/users/verify-email/request", middleware.Authentication(), controller.RequestEmailVerification())
+	incomingRoutes.POST("/users/verify-email/confirm", controller.VerifyEmail())
 
 }
-
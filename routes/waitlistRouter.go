@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "restaurant-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func WaitlistRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/waitlist", allow(allStaff), controller.GetWaitlist())
+	incomingRoutes.POST("/waitlist", allow(floorStaff), controller.AddToWaitlist())
+	incomingRoutes.PATCH("/waitlist/:waitlist_entry_id", allow(floorStaff), controller.UpdateWaitlistEntry())
+	incomingRoutes.POST("/waitlist/:waitlist_entry_id/seat", allow(floorStaff), controller.SeatWaitlistEntry())
+
+}